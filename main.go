@@ -2,7 +2,10 @@ package main
 
 import (
 	"context"
+	"errors"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/go-logr/zapr"
 	"github.com/solo-io/kdiag/pkg/cmd/diag"
@@ -15,13 +18,24 @@ import (
 )
 
 func main() {
-	ctx := log.InitialCmdContext(context.Background())
+	// cancel the context on the first interrupt, so commands can clean up and print their summaries.
+	// the second interrupt kills us as usual.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	ctx = log.InitialCmdContext(ctx)
 	klog.SetLogger(zapr.NewLogger(log.WithContext(ctx)))
 	flags := pflag.NewFlagSet("kubectl-diag", pflag.ExitOnError)
 	pflag.CommandLine = flags
 
 	root := diag.NewCmdDiag(genericclioptions.IOStreams{In: os.Stdin, Out: os.Stdout, ErrOut: os.Stderr})
 	if err := root.ExecuteContext(ctx); err != nil {
+		var exitErr *diag.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.Code)
+		}
 		os.Exit(1)
 	}
 }
@@ -10,8 +10,98 @@ import (
 	"github.com/spf13/cobra"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+	"k8s.io/kubectl/pkg/util/term"
 )
 
+// TransportErrorExitCode is the exit code used when a command could not be run in the pod, to
+// distinguish it from the exit code of the command itself.
+const TransportErrorExitCode = 255
+
+// ExitError is returned by commands that need the cli to exit with a specific exit code.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// remoteExitError converts an error from running a command in the pod to an ExitError, so the exit
+// code of the remote command becomes ours.
+func remoteExitError(err error) error {
+	if err == nil {
+		return nil
+	}
+	// the command ran, and exited with a non-zero status. propagate it as our exit code.
+	if isRemoteExit(err) {
+		code := err.(utilexec.ExitError).ExitStatus()
+		return &ExitError{
+			Code: code,
+			Err:  fmt.Errorf("command terminated with exit code %d", code),
+		}
+	}
+	return &ExitError{
+		Code: TransportErrorExitCode,
+		Err:  fmt.Errorf("failed to execute command: %v", err),
+	}
+}
+
+// isRemoteExit returns true if the error is a command in the pod exiting with a non-zero status.
+func isRemoteExit(err error) bool {
+	exitErr, ok := err.(utilexec.ExitError)
+	return ok && exitErr.Exited()
+}
+
+// streamInContainer executes a command in a container of a pod, and streams its io.
+func (o *DiagOptions) streamInContainer(podName, container string, cmd []string, streamOpts remotecommand.StreamOptions) error {
+	execRequest := o.clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Name(podName).
+		Namespace(o.resultingContext.Namespace).
+		SubResource("exec")
+
+	execRequest.VersionedParams(&corev1.PodExecOptions{
+		Container: container,
+		Command:   cmd,
+		Stdin:     streamOpts.Stdin != nil,
+		Stdout:    streamOpts.Stdout != nil,
+		Stderr:    streamOpts.Stderr != nil,
+		TTY:       streamOpts.Tty,
+	}, scheme.ParameterCodec)
+
+	exec, err := remotecommand.NewSPDYExecutor(o.restConfig, "POST", execRequest.URL())
+	if err != nil {
+		return fmt.Errorf("failed to create executor: %v", err)
+	}
+	return exec.Stream(streamOpts)
+}
+
+func (o *DiagOptions) SetupTTY() term.TTY {
+	t := term.TTY{
+		Parent: nil,
+		Out:    o.Out,
+		In:     o.In,
+		Raw:    true,
+	}
+
+	t.In = o.In
+
+	if !t.IsTerminalIn() {
+		fmt.Fprintln(o.ErrOut, "Unable to use a TTY - input is not a terminal or the right kind of file")
+		return t
+	}
+
+	return t
+}
+
 func AddSinglePodFlags(cmd *cobra.Command, o *DiagOptions) {
 	cmd.PersistentFlags().StringVar(&o.podName, "pod", "", "podname to diagnose")
 	cmd.PersistentFlags().StringVarP(&o.targetContainerName, "target", "t", "", "target container to diagnose, defaults to first container in pod")
@@ -27,20 +117,17 @@ func ValidateSinglePodFlags(o *DiagOptions) error {
 		return fmt.Errorf("one of pod-name,label-selector must be provided, but not both")
 	}
 	if !havePodName {
-		pl, err := o.clientset.CoreV1().Pods(o.resultingContext.Namespace).List(o.ctx, metav1.ListOptions{LabelSelector: o.labelSelector})
+		pods, err := o.listLabeledPods()
 		if err != nil {
 			return err
 		}
-		pods := pl.Items
-		if len(pods) == 0 {
-			return fmt.Errorf("no pods found")
-		}
-		sort.Slice(pods, func(i, j int) bool {
-			return pods[i].CreationTimestamp.Before(&pods[j].CreationTimestamp)
-		})
 		o.podName = pods[len(pods)-1].Name
 	}
 
+	return validatePullPolicy(o)
+}
+
+func validatePullPolicy(o *DiagOptions) error {
 	switch o.pullPolicyString {
 	case string(corev1.PullIfNotPresent), string(corev1.PullAlways), string(corev1.PullNever):
 		// ok
@@ -52,6 +139,22 @@ func ValidateSinglePodFlags(o *DiagOptions) error {
 	return nil
 }
 
+// listLabeledPods returns the pods matching the label selector, oldest first.
+func (o *DiagOptions) listLabeledPods() ([]corev1.Pod, error) {
+	pl, err := o.clientset.CoreV1().Pods(o.resultingContext.Namespace).List(o.ctx, metav1.ListOptions{LabelSelector: o.labelSelector})
+	if err != nil {
+		return nil, err
+	}
+	pods := pl.Items
+	if len(pods) == 0 {
+		return nil, fmt.Errorf("no pods found")
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		return pods[i].CreationTimestamp.Before(&pods[j].CreationTimestamp)
+	})
+	return pods, nil
+}
+
 func CommandName() string {
 	if strings.HasPrefix(filepath.Base(os.Args[0]), "kubectl-") {
 		return "kubectl diag"
@@ -0,0 +1,244 @@
+package diag
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/solo-io/kdiag/pkg/cp"
+	"github.com/solo-io/kdiag/pkg/manager"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+var (
+	cpExample = `
+	Copy files and directories to and from a container. Unlike regular "kubectl cp" this command works
+	even in distroless and scratch containers, as it uses the tar from the debug image. File modes are
+	preserved, but not the owners: copies are owned by root in the pod, and by you locally.
+
+	Copy the envoy config dump from the istio-proxy container to the local machine:
+
+	%[1]s -n bookinfo -t istio-proxy cp productpage-v1-5d9b4c9849-9gkqn:/tmp/config_dump.json ./config_dump.json
+
+	When selecting the pod with a label, the pod name can be omitted:
+
+	%[1]s -n bookinfo -l app=productpage -t istio-proxy cp :/var/lib/istio/data ./data
+
+	Copy a local directory to the container:
+
+	%[1]s -n bookinfo -l app=productpage -t istio-proxy cp ./certs :/tmp/certs
+`
+)
+
+// CpOptions provides information required to update
+// the current context on a user's KUBECONFIG
+type CpOptions struct {
+	*DiagOptions
+	targetProcessOptions
+
+	src      string
+	dest     string
+	download bool
+}
+
+// NewCpOptions provides an instance of CpOptions with default values
+func NewCpOptions(diagOptions *DiagOptions) *CpOptions {
+	return &CpOptions{
+		DiagOptions: diagOptions,
+	}
+}
+
+// NewCmdCp provides a cobra command wrapping CpOptions
+func NewCmdCp(diagOptions *DiagOptions) *cobra.Command {
+	o := NewCpOptions(diagOptions)
+
+	cmd := &cobra.Command{
+		Use:          "cp [pod]:src dest | src [pod]:dest",
+		Short:        "Copy files and directories to and from a container, even without tar in its image",
+		Example:      fmt.Sprintf(cpExample, CommandName()),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(c, args); err != nil {
+				return err
+			}
+			if err := o.Validate(); err != nil {
+				return err
+			}
+			if err := o.Run(); err != nil {
+				return err
+			}
+
+			return nil
+		},
+	}
+	AddSinglePodFlags(cmd, o.DiagOptions)
+	AddTargetProcessFlags(cmd, &o.targetProcessOptions)
+	return cmd
+}
+
+// splitRemotePath splits a [pod]:path argument. returns false if the argument is a local path.
+func splitRemotePath(arg string) (string, string, bool) {
+	index := strings.IndexByte(arg, ':')
+	// a local path may contain a colon, but pod names can't contain a slash.
+	if index < 0 || strings.ContainsRune(arg[:index], '/') {
+		return "", "", false
+	}
+	return arg[:index], arg[index+1:], true
+}
+
+// Complete sets all information required for updating the current context
+func (o *CpOptions) Complete(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("source and destination are required")
+	}
+	srcPod, srcPath, srcRemote := splitRemotePath(args[0])
+	destPod, destPath, destRemote := splitRemotePath(args[1])
+	if srcRemote == destRemote {
+		return fmt.Errorf("exactly one of source and destination must be in a pod")
+	}
+
+	podName := destPod
+	if srcRemote {
+		podName = srcPod
+		o.download = true
+		o.src = srcPath
+		o.dest = args[1]
+	} else {
+		o.src = args[0]
+		o.dest = destPath
+	}
+
+	if podName != "" {
+		if o.podName != "" && o.podName != podName {
+			return fmt.Errorf("pod %s doesn't match pod flag %s", podName, o.podName)
+		}
+		o.podName = podName
+	}
+
+	return nil
+}
+
+// Validate ensures that all required arguments and flag values are provided
+func (o *CpOptions) Validate() error {
+	if o.src == "" || o.dest == "" {
+		return fmt.Errorf("source and destination paths can't be empty")
+	}
+	if err := o.targetProcessOptions.Validate(); err != nil {
+		return err
+	}
+	return ValidateSinglePodFlags(o.DiagOptions)
+}
+
+// Run lists all available namespaces on a user's KUBECONFIG or updates the
+// current context based on a provided namespace.
+func (o *CpOptions) Run() error {
+	mgr := manager.NewEmephemeralContainerManager(o.clientset.CoreV1())
+
+	podObj, containerName, err := mgr.EnsurePodManaged(o.ctx, o.resultingContext.Namespace, o.podName, o.dbgContainerImage, o.targetContainerName, o.pullPolicy)
+	if err != nil {
+		return &ExitError{Code: TransportErrorExitCode, Err: fmt.Errorf("failed to ensure pod managed: %v", err)}
+	}
+	// copies can't be recorded as terminal sessions. don't bypass the policy.
+	if _, ok := podObj.Annotations[RecordAnnotation]; ok {
+		return &ExitError{Code: TransportErrorExitCode, Err: fmt.Errorf("pod %s requires session recording, which is not supported by cp", o.podName)}
+	}
+
+	pid, err := o.resolveTargetPid(podObj, containerName, &o.targetProcessOptions, o.ErrOut)
+	if err != nil {
+		return &ExitError{Code: TransportErrorExitCode, Err: err}
+	}
+
+	if o.download {
+		return o.copyFromPod(containerName, pid)
+	}
+	return o.copyToPod(containerName, pid)
+}
+
+// ashCommand returns a command that runs an ash script in the namespaces of the target process.
+// args are available to the script as positional parameters, so they don't need to be quoted.
+func ashCommand(pid uint64, script string, args ...string) []string {
+	cmd := append(enterCommand(pid, enterOptions{}), "/usr/local/bin/ash", "-c", script, "ash")
+	return append(cmd, args...)
+}
+
+func (o *CpOptions) copyFromPod(containerName string, pid uint64) error {
+	src := path.Clean(o.src)
+	name := path.Base(src)
+	if name == "/" || name == "." {
+		return fmt.Errorf("invalid source path %s", o.src)
+	}
+
+	// copy into existing directories, like cp does.
+	dest := o.dest
+	if fi, err := os.Stat(dest); err == nil && fi.IsDir() {
+		dest = filepath.Join(dest, name)
+	}
+
+	reader, writer := io.Pipe()
+	errCh := make(chan error, 1)
+	go func() {
+		defer reader.Close()
+		errCh <- cp.Extract(reader, name, dest, o.ErrOut)
+	}()
+
+	cmd := ashCommand(pid, `exec tar cf - -C "$(dirname "$1")" "$(basename "$1")"`, src)
+	err := o.streamInContainer(o.podName, containerName, cmd, remotecommand.StreamOptions{
+		Stdout: writer,
+		Stderr: o.ErrOut,
+	})
+	writer.CloseWithError(err)
+	extractErr := <-errCh
+	// if the extraction failed first, the stream fails with a less informative error.
+	if extractErr != nil && !isRemoteExit(err) {
+		return extractErr
+	}
+	return remoteExitError(err)
+}
+
+func (o *CpOptions) copyToPod(containerName string, pid uint64) error {
+	if _, err := os.Stat(o.src); err != nil {
+		return err
+	}
+
+	// copy into existing directories, like cp does.
+	dest := path.Clean(o.dest)
+	dir, name := path.Dir(dest), path.Base(dest)
+	err := o.streamInContainer(o.podName, containerName, ashCommand(pid, `[ -d "$1" ]`, dest), remotecommand.StreamOptions{
+		Stderr: o.ErrOut,
+	})
+	if err == nil {
+		dir, name = dest, filepath.Base(o.src)
+	} else if exitErr := remoteExitError(err); !isExitCode(exitErr, 1) {
+		return exitErr
+	}
+
+	reader, writer := io.Pipe()
+	errCh := make(chan error, 1)
+	go func() {
+		err := cp.Archive(writer, o.src, name)
+		writer.CloseWithError(err)
+		errCh <- err
+	}()
+
+	cmd := ashCommand(pid, `exec tar xf - -C "$1"`, dir)
+	err = o.streamInContainer(o.podName, containerName, cmd, remotecommand.StreamOptions{
+		Stdin:  reader,
+		Stderr: o.ErrOut,
+	})
+	reader.Close()
+	// if archiving failed, the remote tar only got part of the archive.
+	if archiveErr := <-errCh; archiveErr != nil && !errors.Is(archiveErr, io.ErrClosedPipe) {
+		return archiveErr
+	}
+	return remoteExitError(err)
+}
+
+func isExitCode(err error, code int) bool {
+	var exitErr *ExitError
+	return errors.As(err, &exitErr) && exitErr.Code == code
+}
@@ -99,6 +99,8 @@ func NewCmdDiag(streams genericclioptions.IOStreams) *cobra.Command {
 		NewCmdShell(o),
 		NewCmdManage(o),
 		NewCmdLogs(o),
+		NewCmdCp(o),
+		NewCmdExec(o),
 	)
 
 	return cmd
@@ -0,0 +1,190 @@
+package diag
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/solo-io/kdiag/pkg/enter"
+	"github.com/solo-io/kdiag/pkg/manager"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/kubectl/pkg/util/term"
+)
+
+var (
+	execExample = `
+	Run a tool from the debug image in the namespaces of a container. This works even in distroless and
+	scratch containers, and gives access to the tools in the debug image (curl, iptables, nft, strace, ip, ...).
+
+	By default the tool runs in the network, pid, ipc and uts namespaces of the target container, but
+	sees the file system of the debug image. This way dynamically linked tools work as usual.
+	For example, get the envoy config dump from the istio-proxy container:
+
+	%[1]s -l app=productpage -n bookinfo -t istio-proxy exec -- curl -s localhost:15000/config_dump
+
+	Use --root to also enter the mount namespace and root directory of the target container. Like the
+	shell command, this only works with statically linked tools.
+
+	%[1]s -l app=productpage -n bookinfo -t istio-proxy exec --root -- /usr/local/bin/ash -c "ls /etc"
+
+	Entering the pid namespace only applies to the processes the tool starts, not to the tool itself,
+	which stays in the pid namespace of the kdiag manager. Tools like ps and top list the processes of
+	the /proc they see: the ones of the target container with --root, the ones of the manager otherwise.
+
+	The exit code of the tool is used as the exit code of this command. If the tool could not be run in
+	the pod, the exit code is %[2]d.
+
+	Like shell sessions, tools run with exec are recorded with --record, and always on pods with the
+	%[3]s annotation.
+`
+)
+
+// ExecOptions provides information required to update
+// the current context on a user's KUBECONFIG
+type ExecOptions struct {
+	*DiagOptions
+	targetProcessOptions
+	recordOptions
+	stdin bool
+	tty   bool
+	root  bool
+	args  []string
+}
+
+// NewExecOptions provides an instance of ExecOptions with default values
+func NewExecOptions(diagOptions *DiagOptions) *ExecOptions {
+	return &ExecOptions{
+		DiagOptions: diagOptions,
+	}
+}
+
+// NewCmdExec provides a cobra command wrapping ExecOptions
+func NewCmdExec(diagOptions *DiagOptions) *cobra.Command {
+	o := NewExecOptions(diagOptions)
+
+	cmd := &cobra.Command{
+		Use:          "exec -- tool [args...]",
+		Short:        "Run a tool from the debug image in the namespaces of a container",
+		Example:      fmt.Sprintf(execExample, CommandName(), TransportErrorExitCode, RecordAnnotation),
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(c, args); err != nil {
+				return err
+			}
+			if err := o.Validate(); err != nil {
+				return err
+			}
+			if err := o.Run(); err != nil {
+				return err
+			}
+
+			return nil
+		},
+	}
+	AddSinglePodFlags(cmd, o.DiagOptions)
+	AddTargetProcessFlags(cmd, &o.targetProcessOptions)
+	AddRecordFlags(cmd, &o.recordOptions)
+	cmd.Flags().BoolVarP(&o.stdin, "stdin", "i", false, "pass stdin to the tool")
+	cmd.Flags().BoolVar(&o.tty, "tty", false, "allocate a TTY for the tool. implies --stdin")
+	cmd.Flags().BoolVar(&o.root, "root", false, "also enter the mount namespace and root directory of the target. the tool must be statically linked")
+	return cmd
+}
+
+// Complete sets all information required for updating the current context
+func (o *ExecOptions) Complete(cmd *cobra.Command, args []string) error {
+	o.args = args
+	if o.tty {
+		o.stdin = true
+	}
+
+	return nil
+}
+
+// Validate ensures that all required arguments and flag values are provided
+func (o *ExecOptions) Validate() error {
+	if len(o.args) == 0 {
+		return fmt.Errorf("a tool to run must be specified")
+	}
+	if err := o.targetProcessOptions.Validate(); err != nil {
+		return err
+	}
+	if err := o.recordOptions.Validate(); err != nil {
+		return err
+	}
+	return ValidateSinglePodFlags(o.DiagOptions)
+}
+
+// Run lists all available namespaces on a user's KUBECONFIG or updates the
+// current context based on a provided namespace.
+func (o *ExecOptions) Run() error {
+	mgr := manager.NewEmephemeralContainerManager(o.clientset.CoreV1())
+
+	podObj, containerName, err := mgr.EnsurePodManaged(o.ctx, o.resultingContext.Namespace, o.podName, o.dbgContainerImage, o.targetContainerName, o.pullPolicy)
+	if err != nil {
+		return &ExitError{Code: TransportErrorExitCode, Err: fmt.Errorf("failed to ensure pod managed: %v", err)}
+	}
+	// the tool may be a shell, so the recording policy of shell sessions applies to it.
+	if err := o.applyPolicy(podObj); err != nil {
+		return &ExitError{Code: TransportErrorExitCode, Err: err}
+	}
+
+	pid, err := o.resolveTargetPid(podObj, containerName, &o.targetProcessOptions, o.ErrOut)
+	if err != nil {
+		return &ExitError{Code: TransportErrorExitCode, Err: err}
+	}
+	enterOpts := enterOptions{namespaces: []enter.Namespace{enter.Net, enter.PID, enter.IPC, enter.UTS}}
+	if o.root {
+		enterOpts = enterOptions{}
+	}
+	cmd := enterCommand(pid, enterOpts)
+	cmd = append(cmd, o.args...)
+
+	streamOpts := remotecommand.StreamOptions{
+		Stdout: o.Out,
+		Stderr: o.ErrOut,
+	}
+	if o.stdin {
+		streamOpts.Stdin = o.In
+	}
+
+	safe := func(fn term.SafeFunc) error {
+		return fn()
+	}
+	var size *remotecommand.TerminalSize
+	if o.tty {
+		t := o.SetupTTY()
+		if t.IsTerminalIn() {
+			size = t.GetSize()
+			// this call spawns a goroutine to monitor/update the terminal size
+			streamOpts.TerminalSizeQueue = t.MonitorSize(size)
+			streamOpts.Tty = true
+			// stdout and stderr go over stdout when using a tty
+			streamOpts.Stderr = nil
+			safe = t.Safe
+		}
+	}
+
+	var rec *sessionRecording
+	if o.record {
+		title := fmt.Sprintf("kdiag exec %s -- %s", o.podName, strings.Join(o.args, " "))
+		rec, err = o.startRecording(o.podName, title, "", cmd, size, &streamOpts)
+		if err != nil {
+			return &ExitError{Code: TransportErrorExitCode, Err: err}
+		}
+	}
+
+	err = remoteExitError(safe(func() error {
+		return o.streamInContainer(o.podName, containerName, cmd, streamOpts)
+	}))
+	if rec != nil {
+		if recErr := o.finishRecording(rec, &o.recordOptions, o.podName, containerName); recErr != nil {
+			recErr = fmt.Errorf("failed to save the session recording: %w", recErr)
+			if err == nil {
+				return recErr
+			}
+			// the session's own error is returned, so report this one here.
+			fmt.Fprintln(o.ErrOut, recErr)
+		}
+	}
+	return err
+}
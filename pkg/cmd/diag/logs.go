@@ -1,19 +1,22 @@
 package diag
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"regexp"
 	"strings"
 	"time"
 
 	"github.com/fatih/color"
 	"github.com/moby/term"
-	"github.com/samber/lo"
 	"github.com/solo-io/kdiag/pkg/logs"
 	"github.com/spf13/cobra"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 var (
@@ -30,6 +33,82 @@ var (
 	This examples gets the logs from the "istio-proxy" container from all the pods with the app=productpage label
 
 	%[1]s logs -n bookinfo -l app=productpage:istio-proxy -- curl http://foo.bar.com
+
+	Follow a request across namespaces, e.g. from the ingress gateway to the app, with
+	[namespace/]selector[:container]. The names of the pods then include their namespace:
+
+	%[1]s logs -l istio-system/app=istio-ingressgateway -l bookinfo/app=productpage:istio-proxy -- curl http://foo.bar.com
+
+	Use --all-namespaces to select the pods of --all and --labels in all namespaces. A label selector
+	may start with a key prefix that is also a valid namespace name; it is then read as the namespace.
+
+	Container names can be globs, that also match init and ephemeral containers. For example, follow
+	the istio containers, or the logs of the kdiag manager itself:
+
+	%[1]s logs -n bookinfo -l app=productpage -c 'istio-*' -- curl http://foo.bar.com
+	%[1]s logs -n bookinfo --pod productpage-v1-123:'dbg-tools-*'
+
+	Use --all-containers to follow all the containers of the pods.
+
+	Streams that fail are retried with backoff, and reconnect from their last line. The streams that
+	failed for good are listed when done, and only fail the command if all of them failed.
+
+	Turn a smoke test into a check of the logs: fail if any gateway logs an upstream connect error while
+	the script runs. Matches are highlighted, and --highlight highlights other patterns:
+
+	%[1]s logs -l app=gateway --fail-on 'upstream connect error' --highlight ' 5[0-9][0-9] ' -- ./smoke.sh
+
+	The exit code of the command is kept, so the logs can wrap commands in scripts. If the command
+	succeeds, lines matching --fail-on make the exit code 1.
+
+	Lines from different pods are printed as they arrive, so network delays can mix up their order. Use
+	--reorder-window to print them in the order they were logged, at the cost of a small delay. Add
+	--timestamps to show when each line was logged:
+
+	%[1]s logs -n bookinfo --all -c istio-proxy --reorder-window 500ms --timestamps -- curl http://foo.bar.com
+
+	Parse json, logfmt or envoy access logs to filter and project them. For example, show the failed
+	requests in the access logs of the sidecars:
+
+	%[1]s logs -n bookinfo --all -c istio-proxy --parse envoy --where 'response_code>=500' --fields method,path,response_code,response_flags
+
+	Lines that can't be parsed are filtered out by --where, and printed as is otherwise.
+
+	Follow a single request through the mesh. A request id is injected to curl commands (other commands
+	can use the %[2]s environment variable), and only the log lines that mention it are shown, as a
+	timeline of the hops of the request:
+
+	%[1]s logs -n bookinfo --all -c istio-proxy --trace -- curl http://foo.bar.com
+
+	Without a command, the first request id found in the access logs is traced, until interrupted.
+
+	Use --watch to also follow pods that are created after the command started (e.g. during a rollout),
+	and to re-attach to containers after they restart:
+
+	%[1]s logs -n bookinfo -l app=productpage:istio-proxy --watch
+
+	Print the lines as json records, e.g. to process them with jq, and save the lines of each pod and
+	container to a file of its own:
+
+	%[1]s logs -n bookinfo --all -c istio-proxy -o jsonl --output-dir ./session -- curl http://foo.bar.com
+
+	In jsonl output, the output of the command and the other messages are printed to stderr.
+
+	Collect the logs of the sidecars that crashed across a deployment. Containers that didn't restart
+	are reported and skipped:
+
+	%[1]s logs -n bookinfo -l app=productpage:istio-proxy --previous
+
+	Use --snapshot to print the logs available now and exit, and --since, --since-time or --tail to
+	also show older lines, e.g. the last 100 lines of each pod before following them:
+
+	%[1]s logs -n bookinfo --all -c istio-proxy --tail 100 -- curl http://foo.bar.com
+
+	When many pods are printing, use --tui to go through their lines in a terminal ui. Pause with
+	space, search with / (n and N go to the next matches), filter with f, and toggle pods with the keys
+	1 to 9 and 0 (a shows all of them). The output of the command is shown in the ui too:
+
+	%[1]s logs -n bookinfo --all -c istio-proxy --tui
 `
 )
 
@@ -42,12 +121,44 @@ type LogsOptions struct {
 	podNames       []string
 	labelSelectors []string
 	all            bool
+	allNamespaces  bool
 	containerName  string
+	allContainers  bool
 	args           []string
 	drainTime      time.Duration
 	noColor        bool
+	watch          bool
+	timestamps     bool
+	reorderWindow  time.Duration
+	parse          string
+	where          []string
+	fields         []string
+	processor      *logs.LineProcessor
+	trace          bool
+	requestID      string
+	output         string
+	outputDir      string
+	quiet          bool
+	since          time.Duration
+	sinceTime      string
+	tail           int64
+	previous       bool
+	snapshot       bool
+	highlight      []string
+	failOn         []string
+	maxRequests    int
+	retries        int
+	tui            bool
+
+	parsedSinceTime time.Time
+	highlightRes    []*regexp.Regexp
+	failOnRes       []*regexp.Regexp
+
+	// pods from more than one namespace may be selected, so their names include their namespace.
+	crossNamespace bool
 
 	podAndContainerNames []logs.PodAndContainerName
+	podSelectors         []logs.PodSelector
 }
 
 // NewLogsOptions provides an instance of LogsOptions with default values
@@ -64,7 +175,7 @@ func NewCmdLogs(diagOptions *DiagOptions) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:          "logs",
 		Short:        "View logs from multiple containers",
-		Example:      fmt.Sprintf(logExample, CommandName()),
+		Example:      fmt.Sprintf(logExample, CommandName(), logs.RequestIDEnv),
 		SilenceUsage: true,
 		RunE: func(c *cobra.Command, args []string) error {
 			if err := o.Complete(c, args); err != nil {
@@ -80,12 +191,35 @@ func NewCmdLogs(diagOptions *DiagOptions) *cobra.Command {
 			return nil
 		},
 	}
-	cmd.Flags().StringArrayVar(&o.podNames, "pod", nil, "podname to view logs of. you can use podname:containername to specify container name")
-	cmd.Flags().StringArrayVarP(&o.labelSelectors, "labels", "l", nil, "select a pods to watch logs by label. you can use k=v:containername to specify container name")
+	cmd.Flags().StringArrayVar(&o.podNames, "pod", nil, "podname to view logs of. you can use [namespace/]podname[:containername] to specify the namespace and container name")
+	cmd.Flags().StringArrayVarP(&o.labelSelectors, "labels", "l", nil, "select a pods to watch logs by label. you can use [namespace/]k=v[:containername] to specify the namespace and container name")
 	cmd.Flags().BoolVarP(&o.all, "all", "a", false, "select all pods in the namespace")
-	cmd.Flags().StringVarP(&o.containerName, "container", "c", "", "default container name to use for logs. defaults to first container in the pod")
+	cmd.Flags().BoolVarP(&o.allNamespaces, "all-namespaces", "A", false, "select the pods of --all and --labels in all namespaces")
+	cmd.Flags().StringVarP(&o.containerName, "container", "c", "", "default container name to use for logs, or a glob such as 'istio-*' that also matches init and ephemeral containers. defaults to first container in the pod")
+	cmd.Flags().BoolVar(&o.allContainers, "all-containers", false, "follow all the containers of the pods, including init and ephemeral containers (e.g. the kdiag manager)")
 	cmd.Flags().DurationVarP(&o.drainTime, "drain-duration", "d", time.Second/2, "duration to wait for logs after command exits")
 	cmd.Flags().BoolVar(&o.noColor, "no-color", false, "Disable color output")
+	cmd.Flags().BoolVar(&o.timestamps, "timestamps", false, "show the time each line was logged")
+	cmd.Flags().DurationVar(&o.reorderWindow, "reorder-window", 0, "buffer lines for this long (e.g. 500ms), and print them in the order they were logged rather than the order they arrived")
+	cmd.Flags().StringVar(&o.parse, "parse", "", "parse the lines as json, logfmt or envoy (access logs), to use --where and --fields")
+	cmd.Flags().StringArrayVar(&o.where, "where", nil, "only print parsed lines where a field matches, e.g. 'level=error' or 'response_code>=500'. operators: = != > >= < <= ~ (regex). can be repeated")
+	cmd.Flags().StringSliceVar(&o.fields, "fields", nil, "only print these fields of parsed lines, e.g. 'method,path,response_code'")
+	cmd.Flags().BoolVar(&o.trace, "trace", false, "only show the log lines of one request, as a timeline of its hops. a request id is injected to curl commands")
+	cmd.Flags().StringVar(&o.requestID, "request-id", "", "the request id to trace. defaults to a new id when running a command, or to the first id found in the logs otherwise")
+	cmd.Flags().StringVarP(&o.output, "output", "o", string(logs.OutputText), "output format of the lines: text or jsonl (a json record per line, with its pod, container, namespace and timestamp)")
+	cmd.Flags().StringVar(&o.outputDir, "output-dir", "", "also write the lines of each pod and container to a file in this directory, in the output format")
+	cmd.Flags().BoolVar(&o.quiet, "quiet", false, "don't print the lines to stdout. requires --output-dir")
+	cmd.Flags().DurationVar(&o.since, "since", 0, "also show the lines logged in this duration (e.g. 5m) before the command started. defaults to only new lines when following, and to all lines otherwise")
+	cmd.Flags().StringVar(&o.sinceTime, "since-time", "", "also show the lines logged since this RFC3339 time (e.g. 2022-06-01T10:00:00Z)")
+	cmd.Flags().Int64Var(&o.tail, "tail", -1, "also show this many lines from the end of the logs")
+	cmd.Flags().BoolVarP(&o.previous, "previous", "p", false, "print the logs of the previous instance of the containers, e.g. to see why they crashed. implies --snapshot")
+	cmd.Flags().BoolVar(&o.snapshot, "snapshot", false, "print the logs available now and exit, instead of following them")
+	cmd.Flags().StringArrayVar(&o.highlight, "highlight", nil, "color the parts of the lines that match this regular expression. can be repeated")
+	cmd.Flags().StringArrayVar(&o.failOn, "fail-on", nil, "exit with a non-zero code if any line matches this regular expression, e.g. in a CI check. can be repeated")
+	cmd.Flags().IntVar(&o.maxRequests, "max-requests", logs.DefaultMaxRequests, "maximum number of requests to open log streams in flight at once, to not flood the api server when following many pods")
+	cmd.Flags().IntVar(&o.retries, "retries", 5, "number of times to retry a log stream that failed in a row, with backoff, before giving up on it")
+	cmd.Flags().BoolVar(&o.tui, "tui", false, "show the lines in an interactive terminal ui, where they can be paused, searched and filtered, and pods toggled on and off")
+	cmd.Flags().BoolVarP(&o.watch, "watch", "w", false, "follow new pods and containers as they start, and re-attach to containers after they restart")
 
 	return cmd
 }
@@ -108,6 +242,36 @@ func (o *LogsOptions) Complete(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// splitNamespace splits the namespace from a [namespace/]selector flag value. label keys may also
+// have a prefix, e.g. app.kubernetes.io/name=foo, so the prefix is only a namespace if it is a valid
+// namespace name.
+func splitNamespace(s string) (string, string) {
+	index := strings.IndexByte(s, '/')
+	if index <= 0 || len(validation.IsDNS1123Label(s[:index])) != 0 {
+		return "", s
+	}
+	return s[:index], s[index+1:]
+}
+
+// selectionNamespace returns the namespace to select pods in, given the namespace of the flag value.
+func (o *LogsOptions) selectionNamespace(namespace string) string {
+	if namespace != "" {
+		return namespace
+	}
+	if o.allNamespaces {
+		return metav1.NamespaceAll
+	}
+	return o.resultingContext.Namespace
+}
+
+// shownNamespace returns the namespace of a pod in its name, if names include namespaces.
+func (o *LogsOptions) shownNamespace(namespace string) string {
+	if !o.crossNamespace {
+		return ""
+	}
+	return namespace
+}
+
 func (o *LogsOptions) getContainerName(ls string) (string, string) {
 	if index := strings.LastIndexByte(ls, ':'); index > 0 {
 		return ls[:index], ls[index+1:]
@@ -117,54 +281,325 @@ func (o *LogsOptions) getContainerName(ls string) (string, string) {
 
 // Validate ensures that all required arguments and flag values are provided
 func (o *LogsOptions) Validate() error {
-	// alias here so less to type
-	type podCntnrName = logs.PodAndContainerName
+	if o.reorderWindow < 0 {
+		return fmt.Errorf("invalid reorder-window: %v", o.reorderWindow)
+	}
+	if err := o.validateContainers(); err != nil {
+		return err
+	}
+	if err := o.validateParse(); err != nil {
+		return err
+	}
+	if err := o.validateTrace(); err != nil {
+		return err
+	}
+	if err := o.validateOutput(); err != nil {
+		return err
+	}
+	if err := o.validateRange(); err != nil {
+		return err
+	}
+	if err := o.validatePatterns(); err != nil {
+		return err
+	}
+	if o.maxRequests <= 0 {
+		return fmt.Errorf("invalid max-requests: %d", o.maxRequests)
+	}
+	if o.retries < 0 {
+		return fmt.Errorf("invalid retries: %d", o.retries)
+	}
+	o.crossNamespace = o.allNamespaces
+	for _, flag := range append(append([]string{}, o.labelSelectors...), o.podNames...) {
+		if ns, _ := splitNamespace(flag); ns != "" {
+			o.crossNamespace = true
+		}
+	}
+	if o.watch {
+		return o.validateWatch()
+	}
+
 	if o.all {
-		pl, err := o.clientset.CoreV1().Pods(o.resultingContext.Namespace).List(o.ctx, metav1.ListOptions{})
+		pl, err := o.clientset.CoreV1().Pods(o.selectionNamespace("")).List(o.ctx, metav1.ListOptions{})
 		if err != nil {
 			return err
 		}
-		o.podAndContainerNames = lo.Map(pl.Items, func(p corev1.Pod, _ int) podCntnrName {
-			return podCntnrName{PodName: p.Name, ContainerName: o.containerName}
-		})
+		o.addContainers(pl.Items, o.containerName)
 	} else {
 		for _, ls := range o.labelSelectors {
+			ns, ls := splitNamespace(ls)
 			ls, c := o.getContainerName(ls)
 
-			pl, err := o.clientset.CoreV1().Pods(o.resultingContext.Namespace).List(o.ctx, metav1.ListOptions{LabelSelector: ls})
+			pl, err := o.clientset.CoreV1().Pods(o.selectionNamespace(ns)).List(o.ctx, metav1.ListOptions{LabelSelector: ls})
 			if err != nil {
 				return err
 			}
-			o.podAndContainerNames = append(o.podAndContainerNames, lo.Map(pl.Items, func(p corev1.Pod, _ int) podCntnrName {
-				return podCntnrName{PodName: p.Name, ContainerName: c}
-			})...)
+			o.addContainers(pl.Items, c)
 		}
 		for _, podName := range o.podNames {
+			ns, podName := splitNamespace(podName)
+			if ns == "" {
+				// a pod name is only unique in a namespace.
+				ns = o.resultingContext.Namespace
+			}
 			n, c := o.getContainerName(podName)
-			o.podAndContainerNames = append(o.podAndContainerNames, podCntnrName{PodName: n, ContainerName: c})
+			if !o.allContainers && !logs.IsContainerGlob(c) {
+				// no need to get the pod to know its containers.
+				o.podAndContainerNames = append(o.podAndContainerNames, logs.PodAndContainerName{Namespace: o.shownNamespace(ns), PodName: n, ContainerName: c})
+				continue
+			}
+			pod, err := o.clientset.CoreV1().Pods(ns).Get(o.ctx, n, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			o.addContainers([]corev1.Pod{*pod}, c)
 		}
 	}
 
 	if len(o.podAndContainerNames) == 0 {
+		if o.containerName != "" || o.allContainers {
+			return fmt.Errorf("no pods or containers found")
+		}
 		return fmt.Errorf("no pods found")
 	}
 
 	return nil
 }
 
+// addContainers adds the streams of the selected containers of the pods.
+func (o *LogsOptions) addContainers(pods []corev1.Pod, containerName string) {
+	for i := range pods {
+		for _, c := range logs.SelectContainers(&pods[i], containerName, o.allContainers) {
+			o.podAndContainerNames = append(o.podAndContainerNames, logs.PodAndContainerName{Namespace: o.shownNamespace(pods[i].Namespace), PodName: pods[i].Name, ContainerName: c})
+		}
+	}
+}
+
+// validateContainers validates the container names and globs of all the flags.
+func (o *LogsOptions) validateContainers() error {
+	names := []string{o.containerName}
+	for _, s := range append(append([]string{}, o.labelSelectors...), o.podNames...) {
+		_, c := o.getContainerName(s)
+		names = append(names, c)
+	}
+	for _, c := range names {
+		if err := logs.ValidateContainerGlob(c); err != nil {
+			return fmt.Errorf("invalid container name %q: %w", c, err)
+		}
+		if o.allContainers && c != "" {
+			return fmt.Errorf("--all-containers can't be used with a container name")
+		}
+	}
+	return nil
+}
+
+func (o *LogsOptions) validateParse() error {
+	if o.parse == "" {
+		if len(o.where) != 0 || len(o.fields) != 0 {
+			return fmt.Errorf("--where and --fields require --parse")
+		}
+		return nil
+	}
+	format, err := logs.ParseFormat(o.parse)
+	if err != nil {
+		return err
+	}
+	o.processor = &logs.LineProcessor{Format: format, Fields: o.fields}
+	for _, expr := range o.where {
+		c, err := logs.ParseCondition(expr)
+		if err != nil {
+			return err
+		}
+		o.processor.Where = append(o.processor.Where, c)
+	}
+	return nil
+}
+
+func (o *LogsOptions) validateTrace() error {
+	if !o.trace {
+		if o.requestID != "" {
+			return fmt.Errorf("--request-id requires --trace")
+		}
+		return nil
+	}
+	if o.processor != nil {
+		return fmt.Errorf("--trace can't be used with --parse")
+	}
+	if o.requestID == "" && len(o.args) != 0 {
+		o.requestID = string(uuid.NewUUID())
+	}
+	return nil
+}
+
+func (o *LogsOptions) validateOutput() error {
+	if _, err := logs.ParseOutput(o.output); err != nil {
+		return err
+	}
+	if o.quiet && o.outputDir == "" {
+		return fmt.Errorf("--quiet requires --output-dir")
+	}
+	if o.trace && (o.output != string(logs.OutputText) || o.outputDir != "") {
+		return fmt.Errorf("--trace can't be used with --output jsonl or --output-dir")
+	}
+	if o.tui && (o.output != string(logs.OutputText) || o.quiet || o.trace || len(o.highlight) != 0) {
+		return fmt.Errorf("--tui can't be used with --output jsonl, --quiet, --trace or --highlight")
+	}
+	return nil
+}
+
+// validateRange validates the flags that select which lines are printed.
+func (o *LogsOptions) validateRange() error {
+	if o.since < 0 {
+		return fmt.Errorf("invalid since: %v", o.since)
+	}
+	if o.sinceTime != "" {
+		if o.since != 0 {
+			return fmt.Errorf("only one of --since or --since-time can be used")
+		}
+		t, err := time.Parse(time.RFC3339, o.sinceTime)
+		if err != nil {
+			return fmt.Errorf("invalid since-time: %w", err)
+		}
+		o.parsedSinceTime = t
+	}
+	if o.previous {
+		o.snapshot = true
+	}
+	if o.snapshot {
+		if len(o.args) != 0 {
+			return fmt.Errorf("--snapshot and --previous can't be used with a command")
+		}
+		if o.watch {
+			return fmt.Errorf("--snapshot and --previous can't be used with --watch")
+		}
+	}
+	return nil
+}
+
+func (o *LogsOptions) validatePatterns() error {
+	compile := func(flag string, exprs []string) ([]*regexp.Regexp, error) {
+		var res []*regexp.Regexp
+		for _, expr := range exprs {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s regular expression %q: %w", flag, expr, err)
+			}
+			res = append(res, re)
+		}
+		return res, nil
+	}
+	var err error
+	if o.highlightRes, err = compile("highlight", o.highlight); err != nil {
+		return err
+	}
+	o.failOnRes, err = compile("fail-on", o.failOn)
+	return err
+}
+
+// validateWatch converts the flags to pod selectors. unlike the static mode, no pods need to
+// match yet.
+func (o *LogsOptions) validateWatch() error {
+	// selectors without a namespace select pods in the current namespace, or in all namespaces.
+	selector := func(namespace string, allNamespaces bool) logs.PodSelector {
+		if namespace == "" && !allNamespaces {
+			namespace = o.shownNamespace(o.resultingContext.Namespace)
+		}
+		return logs.PodSelector{
+			Namespace:     namespace,
+			AllNamespaces: namespace == "" && allNamespaces,
+			AllContainers: o.allContainers,
+		}
+	}
+	if o.all {
+		s := selector("", o.allNamespaces)
+		s.ContainerName = o.containerName
+		o.podSelectors = []logs.PodSelector{s}
+		return nil
+	}
+	for _, ls := range o.labelSelectors {
+		ns, ls := splitNamespace(ls)
+		s := selector(ns, o.allNamespaces)
+		s.LabelSelector, s.ContainerName = o.getContainerName(ls)
+		o.podSelectors = append(o.podSelectors, s)
+	}
+	for _, podName := range o.podNames {
+		ns, podName := splitNamespace(podName)
+		s := selector(ns, false)
+		n, c := o.getContainerName(podName)
+		s.FieldSelector, s.ContainerName = "metadata.name="+n, c
+		o.podSelectors = append(o.podSelectors, s)
+	}
+	if len(o.podSelectors) == 0 {
+		return fmt.Errorf("one of --all, --labels or --pod must be provided")
+	}
+	return nil
+}
+
 // Run lists all available namespaces on a user's KUBECONFIG or updates the
 // current context based on a provided namespace.
 func (o *LogsOptions) Run() error {
+	if o.outputDir != "" {
+		if err := os.MkdirAll(o.outputDir, 0755); err != nil {
+			return fmt.Errorf("failed to create output directory: %w", err)
+		}
+	}
+
 	printer := logs.MultiLogPrinter{
-		Out:          o.Out,
-		ErrOut:       o.ErrOut,
-		In:           o.In,
-		Args:         o.args,
-		LogDrainTime: o.drainTime,
+		Out:           o.Out,
+		ErrOut:        o.ErrOut,
+		In:            o.In,
+		Args:          o.args,
+		LogDrainTime:  o.drainTime,
+		Timestamps:    o.timestamps,
+		ReorderWindow: o.reorderWindow,
+		Processor:     o.processor,
+		Output:        logs.Output(o.output),
+		Namespace:     o.resultingContext.Namespace,
+		OutputDir:     o.outputDir,
+		Quiet:         o.quiet,
+		Since:         o.since,
+		SinceTime:     o.parsedSinceTime,
+		Previous:      o.previous,
+		Snapshot:      o.snapshot,
+		Highlight:     o.highlightRes,
+		FailOn:        o.failOnRes,
+		MaxRequests:   o.maxRequests,
+		Retries:       o.retries,
+		TUI:           o.tui,
+	}
+	if o.tail >= 0 {
+		printer.TailLines = &o.tail
+	}
+
+	if o.trace {
+		printer.Trace = &logs.RequestTrace{ID: o.requestID}
+		if o.requestID != "" {
+			printer.Args = logs.InjectRequestID(o.args, o.requestID)
+			printer.CommandEnv = []string{logs.RequestIDEnv + "=" + o.requestID}
+		}
 	}
 
 	if colorNotAvailable(o.IOStreams.Out) || o.noColor {
 		color.NoColor = true
 	}
-	return printer.PrintLogs(o.ctx, o.clientset.CoreV1().Pods(o.resultingContext.Namespace), o.podAndContainerNames)
+	var err error
+	if o.watch {
+		err = printer.WatchLogs(o.ctx, o.clientset.CoreV1(), o.podSelectors)
+	} else {
+		err = printer.PrintLogs(o.ctx, o.clientset.CoreV1(), o.podAndContainerNames)
+	}
+	return commandExitError(err)
+}
+
+// commandExitError converts the failure of the user command to an ExitError, so its exit code
+// becomes ours.
+func commandExitError(err error) error {
+	var cmdErr *logs.CommandError
+	if !errors.As(err, &cmdErr) || cmdErr.ExitCode() < 0 {
+		return err
+	}
+	code := cmdErr.ExitCode()
+	return &ExitError{
+		Code: code,
+		Err:  fmt.Errorf("command terminated with exit code %d", code),
+	}
 }
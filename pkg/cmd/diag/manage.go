@@ -77,11 +77,11 @@ func (o *ManageOptions) Run() error {
 	// exec!
 	mgr := manager.NewEmephemeralContainerManager(o.clientset.CoreV1())
 
-	_, err := mgr.EnsurePodManaged(o.ctx, o.resultingContext.Namespace, o.podName, o.dbgContainerImage, o.targetContainerName, o.pullPolicy)
+	_, containerName, err := mgr.EnsurePodManaged(o.ctx, o.resultingContext.Namespace, o.podName, o.dbgContainerImage, o.targetContainerName, o.pullPolicy)
 	if err != nil {
 		return fmt.Errorf("failed to ensure pod managed: %v", err)
 	}
 
-	fmt.Fprintf(o.Out, "%s container deployed to manage pod %s\n", mgr.ContainerName(), o.podName)
+	fmt.Fprintf(o.Out, "%s container deployed to manage pod %s\n", containerName, o.podName)
 	return nil
 }
@@ -0,0 +1,226 @@
+package diag
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/solo-io/kdiag/pkg/record"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+const (
+	// RecordAnnotation on a pod makes recording shell sessions to it mandatory. Its value is a comma
+	// separated list of where to keep the recordings: "local", "configmap" and/or "container".
+	RecordAnnotation = "kdiag.solo.io/record-shell"
+	// SessionLabel is set on the config maps that hold recordings.
+	SessionLabel = "kdiag.solo.io/session"
+
+	recordLocal     = "local"
+	recordConfigMap = "configmap"
+	recordContainer = "container"
+
+	// where recordings are uploaded to in the manager container.
+	containerRecordingsDir = "/var/log/kdiag/sessions"
+	// config maps are limited to 1MiB. leave some room for the metadata.
+	maxConfigMapRecordingSize = 1000 * 1000
+)
+
+// recordOptions configure the recording of shell sessions.
+type recordOptions struct {
+	record bool
+	file   string
+	upload []string
+}
+
+func AddRecordFlags(cmd *cobra.Command, o *recordOptions) {
+	cmd.Flags().BoolVar(&o.record, "record", false, "record the session in asciicast v2 format. this is mandatory for pods with the "+RecordAnnotation+" annotation")
+	cmd.Flags().StringVar(&o.file, "record-file", "", "local file to save the recording to. implies --record. defaults to kdiag-<pod>-<time>.cast in the current directory")
+	cmd.Flags().StringSliceVar(&o.upload, "record-upload", nil, "also upload the recording to the pod's namespace: \"configmap\" and/or \"container\" (a file in "+containerRecordingsDir+" in the manager container). implies --record")
+}
+
+func (o *recordOptions) Validate() error {
+	for _, u := range o.upload {
+		if u != recordConfigMap && u != recordContainer {
+			return fmt.Errorf("invalid record-upload %q. must be %s or %s", u, recordConfigMap, recordContainer)
+		}
+	}
+	if o.file != "" || len(o.upload) != 0 {
+		o.record = true
+	}
+	return nil
+}
+
+// applyPolicy turns on recording and uploads required by the pod's annotation.
+func (o *recordOptions) applyPolicy(podObj *corev1.Pod) error {
+	policy, ok := podObj.Annotations[RecordAnnotation]
+	if !ok {
+		return nil
+	}
+	o.record = true
+	for _, mode := range strings.Split(policy, ",") {
+		switch mode = strings.TrimSpace(mode); mode {
+		case recordLocal, "":
+		case recordConfigMap, recordContainer:
+			if !lo.Contains(o.upload, mode) {
+				o.upload = append(o.upload, mode)
+			}
+		default:
+			// fail closed, so a typo in the policy doesn't disable it.
+			return fmt.Errorf("pod %s requires recording with unknown mode %q in its %s annotation", podObj.Name, mode, RecordAnnotation)
+		}
+	}
+	return nil
+}
+
+// sessionRecording records a shell session to a local file.
+type sessionRecording struct {
+	file     *os.File
+	recorder *record.Recorder
+	started  time.Time
+}
+
+// startRecording creates the local recording file, and wraps the stream options so the session is
+// recorded to it. shell is the path of the shell of shell sessions, and empty for other commands.
+func (o *recordOptions) startRecording(podName, title, shell string, cmd []string, size *remotecommand.TerminalSize, streamOpts *remotecommand.StreamOptions) (*sessionRecording, error) {
+	started := time.Now()
+	fileName := o.file
+	if fileName == "" {
+		fileName = fmt.Sprintf("kdiag-%s-%s.cast", podName, started.Format("20060102-150405"))
+	}
+	// recordings may contain secrets.
+	f, err := os.OpenFile(fileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording file: %w", err)
+	}
+
+	header := record.Header{
+		Width:     80,
+		Height:    24,
+		Timestamp: started.Unix(),
+		Command:   strings.Join(cmd, " "),
+		Title:     title,
+		Env:       map[string]string{"TERM": os.Getenv("TERM")},
+	}
+	if shell != "" {
+		header.Env["SHELL"] = shell
+	}
+	if size != nil {
+		header.Width, header.Height = int(size.Width), int(size.Height)
+	}
+	recorder, err := record.NewRecorder(f, header)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if streamOpts.Stdin != nil {
+		streamOpts.Stdin = io.TeeReader(streamOpts.Stdin, recorder.Input())
+	}
+	streamOpts.Stdout = io.MultiWriter(streamOpts.Stdout, recorder.Output())
+	if streamOpts.Stderr != nil {
+		streamOpts.Stderr = io.MultiWriter(streamOpts.Stderr, recorder.Output())
+	}
+	if streamOpts.TerminalSizeQueue != nil {
+		streamOpts.TerminalSizeQueue = &recordingSizeQueue{queue: streamOpts.TerminalSizeQueue, recorder: recorder}
+	}
+	return &sessionRecording{file: f, recorder: recorder, started: started}, nil
+}
+
+// recordingSizeQueue records the terminal size changes.
+type recordingSizeQueue struct {
+	queue    remotecommand.TerminalSizeQueue
+	recorder *record.Recorder
+	started  bool
+}
+
+func (q *recordingSizeQueue) Next() *remotecommand.TerminalSize {
+	size := q.queue.Next()
+	// the first size is the initial one, which is already in the header.
+	if size != nil && q.started {
+		q.recorder.Resize(int(size.Width), int(size.Height))
+	}
+	q.started = true
+	return size
+}
+
+// finishRecording closes the local recording, and uploads it.
+func (o *DiagOptions) finishRecording(rec *sessionRecording, ro *recordOptions, podName, managerContainer string) error {
+	err := rec.recorder.Close()
+	if closeErr := rec.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(o.ErrOut, "session recorded to %s\n", rec.file.Name())
+
+	data, err := os.ReadFile(rec.file.Name())
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("kdiag-%s-%s.cast", podName, rec.started.Format("20060102-150405"))
+	for _, upload := range ro.upload {
+		switch upload {
+		case recordConfigMap:
+			cm, err := o.uploadRecordingToConfigMap(data, podName, rec.started)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(o.ErrOut, "session uploaded to config map %s\n", cm)
+		case recordContainer:
+			dest := path.Join(containerRecordingsDir, name)
+			if err := o.uploadRecordingToContainer(data, podName, managerContainer, dest); err != nil {
+				return err
+			}
+			fmt.Fprintf(o.ErrOut, "session uploaded to %s in container %s\n", dest, managerContainer)
+		}
+	}
+	return nil
+}
+
+func (o *DiagOptions) uploadRecordingToConfigMap(data []byte, podName string, started time.Time) (string, error) {
+	if len(data) > maxConfigMapRecordingSize {
+		return "", fmt.Errorf("recording is too large for a config map (%d bytes)", len(data))
+	}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "kdiag-session-",
+			Labels: map[string]string{
+				SessionLabel: "true",
+			},
+			Annotations: map[string]string{
+				"kdiag.solo.io/pod":     podName,
+				"kdiag.solo.io/started": started.UTC().Format(time.RFC3339),
+			},
+		},
+		Data: map[string]string{
+			"session.cast": string(data),
+		},
+	}
+	cm, err := o.clientset.CoreV1().ConfigMaps(o.resultingContext.Namespace).Create(o.ctx, cm, metav1.CreateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload recording to config map: %w", err)
+	}
+	return cm.Name, nil
+}
+
+func (o *DiagOptions) uploadRecordingToContainer(data []byte, podName, managerContainer, dest string) error {
+	cmd := []string{"/bin/sh", "-c", `mkdir -p "$(dirname "$1")" && cat > "$1" && chmod 600 "$1"`, "sh", dest}
+	err := o.streamInContainer(podName, managerContainer, cmd, remotecommand.StreamOptions{
+		Stdin:  bytes.NewReader(data),
+		Stderr: o.ErrOut,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload recording to container: %w", err)
+	}
+	return nil
+}
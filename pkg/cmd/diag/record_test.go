@@ -0,0 +1,44 @@
+package diag
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+var _ = Describe("startRecording", func() {
+	// header starts a recording of a command, and returns the header of the recording.
+	header := func(title, shell string) map[string]interface{} {
+		o := &recordOptions{record: true, file: filepath.Join(GinkgoT().TempDir(), "session.cast")}
+		streamOpts := remotecommand.StreamOptions{Stdout: &bytes.Buffer{}}
+		rec, err := o.startRecording("pod", title, shell, []string{"curl", "-s", "localhost"}, nil, &streamOpts)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rec.recorder.Close()).To(Succeed())
+		Expect(rec.file.Close()).To(Succeed())
+
+		data, err := os.ReadFile(o.file)
+		Expect(err).NotTo(HaveOccurred())
+		var h map[string]interface{}
+		Expect(json.Unmarshal([]byte(strings.SplitN(string(data), "\n", 2)[0]), &h)).To(Succeed())
+		return h
+	}
+
+	It("should record the shell of shell sessions", func() {
+		h := header("kdiag shell pod", debugImageShell)
+		Expect(h).To(HaveKeyWithValue("title", "kdiag shell pod"))
+		Expect(h).To(HaveKeyWithValue("env", HaveKeyWithValue("SHELL", debugImageShell)))
+	})
+
+	It("should not record a shell for other commands", func() {
+		h := header("kdiag exec pod -- curl -s localhost", "")
+		Expect(h).To(HaveKeyWithValue("title", "kdiag exec pod -- curl -s localhost"))
+		Expect(h).To(HaveKeyWithValue("command", "curl -s localhost"))
+		Expect(h).To(HaveKeyWithValue("env", Not(HaveKey("SHELL"))))
+	})
+})
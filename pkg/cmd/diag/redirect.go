@@ -1,11 +1,12 @@
 package diag
 
 import (
+	"context"
 	"fmt"
-	"strconv"
-	"strings"
+	"math"
 
 	"github.com/samber/lo"
+	"github.com/solo-io/kdiag/pkg/logs"
 	"github.com/solo-io/kdiag/pkg/manager"
 	"github.com/spf13/cobra"
 	"golang.org/x/sync/errgroup"
@@ -25,12 +26,42 @@ var (
 
 	Redirect all listening ports from an istiod pod to localhost:
 	%[1]s redir -l app=istiod -n istio-system
+
+	Redirect port 15012 of all the istiod replicas, including replicas that start later, to the same
+	local port:
+	%[1]s redir -l app=istiod -n istio-system --all-matching 15012
+
+	Redirect the pods of a service. The ports are ports of the service, and are redirected from the
+	target ports of the pods, including named target ports:
+	%[1]s redir -n istio-system svc/istiod 15012
+
+	Ports can also be given by name: the name of a container port of the pod, or of a port of the
+	service. Without a local port, the number of the port is used:
+	%[1]s redir -l app=istiod -n istio-system grpc-xds:15012
+	%[1]s redir -n istio-system svc/istiod grpc-xds
+
+	Redirect the ports declared in the pod spec, rather than the ports that processes listen on:
+	%[1]s redir -l app=istiod -n istio-system --discover declared
+
+	Discovery can include the ports that only listen on loopback addresses, be restricted to the ports
+	of a process, and exclude ports. For example, the ports of envoy but its metrics and health ports:
+	%[1]s redir -l app=productpage -n bookinfo -t istio-proxy --include-loopback --discover-process envoy --exclude-ports 15090,15021
+
+	Connections to localhost inside the pod are not incoming connections. Redirect them with --outgoing
+	--local, e.g. the connections of the app to the admin port of its sidecar:
+	%[1]s redir -l app=productpage -n bookinfo --outgoing --local 15000
+	Only connections to 127.0.0.1 are redirected, not the ones to ::1.
 `
 )
 
 type portPair struct {
 	localPort  uint16
 	remotePort uint16
+	// remoteName is the name of the remote port, when given by name. remotePort is resolved from it
+	// in each pod or service.
+	remoteName string
+	// localFromRemote uses the resolved remote port as the local port.
+	localFromRemote bool
 }
 
 // RedirOptions provides information required to update
@@ -40,7 +71,20 @@ type RedirOptions struct {
 	args      []string
 	portPairs []portPair
 
-	outgoing bool
+	outgoing    bool
+	local       bool
+	allMatching bool
+	// serviceName, when set, redirects the pods of this service. the remote ports are ports of the
+	// service.
+	serviceName string
+	// discover selects the ports to redirect when none are given.
+	discover        string
+	includeLoopback bool
+	discoverPid     int
+	discoverProcess string
+	excludePorts    []int
+	// the number of pods set up at once with allMatching or a service.
+	maxConcurrency int
 }
 
 // NewRedirOptions provides an instance of RedirOptions with default values
@@ -55,7 +99,7 @@ func NewCmdRedir(diagOptions *DiagOptions) *cobra.Command {
 	o := NewRedirOptions(diagOptions)
 
 	cmd := &cobra.Command{
-		Use:          "redir podport:localport",
+		Use:          "redir [svc/name] podport:localport",
 		Short:        "Redirect incoming or outgoing connections of pod locally",
 		Example:      fmt.Sprintf(redirectExample, CommandName()),
 		SilenceUsage: true,
@@ -75,48 +119,33 @@ func NewCmdRedir(diagOptions *DiagOptions) *cobra.Command {
 	}
 	AddSinglePodFlags(cmd, o.DiagOptions)
 	cmd.Flags().BoolVar(&o.outgoing, "outgoing", false, "when set, redirects outgoing connections instead of incoming ones")
+	cmd.Flags().BoolVar(&o.local, "local", false, "with --outgoing, only redirect connections to localhost (127.0.0.1, not ::1) inside the pod, e.g. to a sidecar's admin port. ports are discovered like incoming ones when not given, including the ones that listen on loopback addresses")
+	cmd.Flags().BoolVar(&o.includeLoopback, "include-loopback", false, "also discover the ports that only listen on loopback addresses")
+	cmd.Flags().IntVar(&o.discoverPid, "discover-pid", 0, "only discover the ports that this process listens on")
+	cmd.Flags().StringVar(&o.discoverProcess, "discover-process", "", "only discover the ports that processes with this name listen on. can't be used with --discover-pid")
+	cmd.Flags().IntSliceVar(&o.excludePorts, "exclude-ports", nil, "never discover these ports, e.g. 15090,15021")
+	cmd.Flags().StringVar(&o.discover, "discover", discoverListening, "the ports to redirect when none are given: listening (the ports processes in the pod listen on), declared (the containerPorts of the pod spec) or all")
+	cmd.Flags().IntVar(&o.maxConcurrency, "max-concurrency", logs.DefaultMaxRequests, "maximum number of pods to set up at once with --all-matching or a service, to not flood the api server")
+	cmd.Flags().BoolVar(&o.allMatching, "all-matching", false, "redirect the traffic of all the pods matching --labels, rather than one of them, as they come and go. connections from all of them are sent to the same local ports")
 	return cmd
 }
 
 // Complete sets all information required for updating the current context
 func (o *RedirOptions) Complete(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		if name, ok := serviceName(args[0]); ok {
+			o.serviceName = name
+			args = args[1:]
+		}
+	}
 	o.args = args
 
 	for _, portString := range o.args {
-
-		parts := strings.Split(portString, ":")
-		var localString, remoteString string
-		if len(parts) == 1 {
-			localString = parts[0]
-			remoteString = parts[0]
-		} else if len(parts) == 2 {
-			localString = parts[1]
-			if localString == "" {
-				// support :5000
-				localString = "0"
-			}
-			remoteString = parts[0]
-		} else {
-			return fmt.Errorf("invalid port format '%s'", portString)
-		}
-		localPort, err := strconv.ParseUint(localString, 10, 16)
+		pair, err := parsePortPair(portString)
 		if err != nil {
-			return fmt.Errorf("error parsing local port '%s': %s", localString, err)
-		}
-
-		remotePort, err := strconv.ParseUint(remoteString, 10, 16)
-		if err != nil {
-			return fmt.Errorf("error parsing remote port '%s': %s", remoteString, err)
-		}
-		if remotePort == 0 {
-			return fmt.Errorf("remote port must be > 0")
+			return err
 		}
-
-		o.portPairs = append(o.portPairs, portPair{
-			localPort:  uint16(localPort),
-			remotePort: uint16(remotePort),
-		})
-
+		o.portPairs = append(o.portPairs, pair)
 	}
 
 	return nil
@@ -124,9 +153,51 @@ func (o *RedirOptions) Complete(cmd *cobra.Command, args []string) error {
 
 // Validate ensures that all required arguments and flag values are provided
 func (o *RedirOptions) Validate() error {
-	if o.outgoing && len(o.portPairs) == 0 {
+	if o.local && !o.outgoing {
+		return fmt.Errorf("--local requires --outgoing")
+	}
+	// connections to localhost are to ports of the pod, so they can be discovered and named.
+	if o.outgoing && !o.local && len(o.portPairs) == 0 {
 		return fmt.Errorf("must specify at least one port pair to redirect")
 	}
+	if o.outgoing && !o.local && lo.ContainsBy(o.portPairs, func(p portPair) bool { return p.remoteName != "" }) {
+		return fmt.Errorf("named ports can't be used with --outgoing")
+	}
+	if o.discoverPid < 0 {
+		return fmt.Errorf("invalid discover-pid: %d", o.discoverPid)
+	}
+	if o.discoverPid != 0 && o.discoverProcess != "" {
+		return fmt.Errorf("only one of discover-pid,discover-process can be provided")
+	}
+	for _, port := range o.excludePorts {
+		if port <= 0 || port > math.MaxUint16 {
+			return fmt.Errorf("invalid port in exclude-ports: %d", port)
+		}
+	}
+	if o.maxConcurrency <= 0 {
+		return fmt.Errorf("invalid max-concurrency: %d", o.maxConcurrency)
+	}
+	switch o.discover {
+	case discoverListening, discoverDeclared, discoverAll:
+	default:
+		return fmt.Errorf("invalid discover: %s. must be one of listening, declared or all", o.discover)
+	}
+	if o.serviceName != "" {
+		if o.podName != "" || o.labelSelector != "" || o.allMatching || o.outgoing {
+			return fmt.Errorf("a service can't be used with --pod, --labels, --all-matching or --outgoing")
+		}
+		// the ports of the service are redirected, they are not discovered in the pods.
+		if o.discover != discoverListening || o.includeLoopback || o.discoverPid != 0 || o.discoverProcess != "" || len(o.excludePorts) != 0 {
+			return fmt.Errorf("a service can't be used with --discover, --include-loopback, --discover-pid, --discover-process or --exclude-ports")
+		}
+		return validatePullPolicy(o.DiagOptions)
+	}
+	if o.allMatching {
+		if o.labelSelector == "" || o.podName != "" {
+			return fmt.Errorf("--all-matching requires --labels, and can't be used with --pod")
+		}
+		return validatePullPolicy(o.DiagOptions)
+	}
 
 	return ValidateSinglePodFlags(o.DiagOptions)
 }
@@ -134,24 +205,45 @@ func (o *RedirOptions) Validate() error {
 // Run lists all available namespaces on a user's KUBECONFIG or updates the
 // current context based on a provided namespace.
 func (o *RedirOptions) Run() error {
+	if o.serviceName != "" {
+		return o.runService()
+	}
+	if o.allMatching {
+		return o.runAllMatching()
+	}
+	err := o.redirectPod(o.ctx, o.podName, o.portPairs, func() {})
+	if err != nil {
+		fmt.Fprintf(o.ErrOut, "%v\n", err)
+	}
+	return err
+}
+
+// redirectPod ensures the pod is managed, and redirects the traffic of the ports until the context is
+// done or a redirect fails. The ports are discovered in the pod when there are none. setupDone is
+// called once the pod is set up.
+func (o *RedirOptions) redirectPod(ctx context.Context, podName string, portPairs []portPair, setupDone func()) error {
 	mgr := manager.NewEmephemeralContainerManager(o.clientset.CoreV1())
 
-	_, err := mgr.EnsurePodManaged(o.ctx, o.resultingContext.Namespace, o.podName, o.dbgContainerImage, o.targetContainerName, o.pullPolicy)
+	podObj, containerName, err := mgr.EnsurePodManaged(ctx, o.resultingContext.Namespace, podName, o.dbgContainerImage, o.targetContainerName, o.pullPolicy)
 	if err != nil {
 		return fmt.Errorf("failed to ensure pod managed: %v", err)
 	}
-	ctx := o.ctx
-	mgrmgr, err := manager.NewManager(ctx, o.restConfig, o.clientset, o.Out, o.ErrOut, o.podName, o.resultingContext.Namespace, mgr.ContainerName())
+	mgrmgr, err := manager.NewManager(ctx, o.restConfig, o.clientset, o.Out, o.ErrOut, podName, o.resultingContext.Namespace, containerName)
 	if err != nil {
 		return err
 	}
+	defer mgrmgr.Close()
 
-	if len(o.portPairs) == 0 {
-		ports, err := mgrmgr.GetListeneningPorts(o.ctx)
+	portPairs, err = resolvePodPorts(podObj, portPairs)
+	if err != nil {
+		return err
+	}
+	if len(portPairs) == 0 {
+		ports, err := o.discoverPorts(ctx, podObj, mgrmgr)
 		if err != nil {
 			return err
 		}
-		o.portPairs = lo.Map(ports, func(port uint16, _ int) portPair {
+		portPairs = lo.Map(ports, func(port uint16, _ int) portPair {
 			return portPair{
 				localPort:  port,
 				remotePort: port,
@@ -159,24 +251,29 @@ func (o *RedirOptions) Run() error {
 		})
 	}
 
-	if len(o.portPairs) == 0 {
+	if len(portPairs) == 0 {
 		return fmt.Errorf("no ports to redirect")
 	}
 
-	errGroup, ctx := errgroup.WithContext(o.ctx)
+	setupDone()
+	errGroup, ctx := errgroup.WithContext(ctx)
 
-	for _, portPair := range o.portPairs {
+	for _, portPair := range portPairs {
 		portPair := portPair
 
 		direction := "incoming"
-		if o.outgoing {
+		if o.local {
+			direction = "local"
+		} else if o.outgoing {
 			direction = "outgoing"
 		}
 
-		fmt.Fprintf(o.Out, "redirecting %s traffic from %s:%d to localhost:%d\n", direction, o.podName, portPair.remotePort, portPair.localPort)
+		fmt.Fprintf(o.Out, "redirecting %s traffic from %s:%d to localhost:%d\n", direction, podName, portPair.remotePort, portPair.localPort)
 
 		errGroup.Go(func() error {
-			if o.outgoing {
+			if o.local {
+				return mgrmgr.RedirectLocalTraffic(ctx, portPair.remotePort, portPair.localPort)
+			} else if o.outgoing {
 				return mgrmgr.RedirectOutgoingTraffic(ctx, portPair.remotePort, portPair.localPort)
 			} else {
 				return mgrmgr.RedirectIncomingTraffic(ctx, portPair.remotePort, portPair.localPort)
@@ -185,8 +282,7 @@ func (o *RedirOptions) Run() error {
 	}
 	err = errGroup.Wait()
 	if err != nil {
-		fmt.Fprintf(o.ErrOut, "failed to redirect traffic: %v\n", err)
-		return err
+		return fmt.Errorf("failed to redirect traffic: %w", err)
 	}
 	return nil
 }
@@ -0,0 +1,174 @@
+package diag
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// redirectResync is how often the pods we don't redirect are checked again, so the redirects that
+// failed are retried.
+const redirectResync = 30 * time.Second
+
+// podRedirector redirects the traffic of pods as they come and go, e.g. of all the replicas of a
+// deployment. Connections from all the pods are sent to the same local ports.
+type podRedirector struct {
+	ctx context.Context
+	// redirect calls setupDone once the pod is set up, and the redirects only wait for connections.
+	redirect func(ctx context.Context, podName string, portPairs []portPair, setupDone func()) error
+	errOut   io.Writer
+	// bounds the pods that are set up at once. each setup makes a few requests to the api server,
+	// and starts a port forward.
+	setups chan struct{}
+
+	lock sync.Mutex
+	// the pods we redirect, by uid, so a pod that is re-created with the same name is redirected again.
+	pods map[types.UID]*podRedirect
+	wg   sync.WaitGroup
+}
+
+type podRedirect struct {
+	cancel    context.CancelFunc
+	portPairs []portPair
+}
+
+func newPodRedirector(ctx context.Context, o *RedirOptions) *podRedirector {
+	return &podRedirector{
+		ctx:      ctx,
+		redirect: o.redirectPod,
+		errOut:   o.ErrOut,
+		setups:   make(chan struct{}, o.maxConcurrency),
+		pods:     map[types.UID]*podRedirect{},
+	}
+}
+
+// add redirects the traffic of the ports of the pod, unless we already do. The ports are
+// discovered in the pod when there are none. When the ports changed, the redirects are restarted.
+func (r *podRedirector) add(uid types.UID, podName string, portPairs []portPair) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if existing, ok := r.pods[uid]; ok {
+		if reflect.DeepEqual(existing.portPairs, portPairs) {
+			return
+		}
+		existing.cancel()
+	}
+	ctx, cancel := context.WithCancel(r.ctx)
+	redirect := &podRedirect{cancel: cancel, portPairs: portPairs}
+	r.pods[uid] = redirect
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer cancel()
+		var err error
+		select {
+		case r.setups <- struct{}{}:
+			var release sync.Once
+			setupDone := func() {
+				release.Do(func() { <-r.setups })
+			}
+			// select picks either when the pod was removed before we waited.
+			if ctx.Err() == nil {
+				err = r.redirect(ctx, podName, portPairs, setupDone)
+			}
+			setupDone()
+		case <-ctx.Done():
+		}
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		if ctx.Err() == nil && err != nil {
+			fmt.Fprintf(r.errOut, "pod %s: %v. retrying within %v\n", podName, err, redirectResync)
+		}
+		// forget the pod, so it is retried on its next update.
+		if r.pods[uid] == redirect {
+			delete(r.pods, uid)
+		}
+	}()
+}
+
+// addPod redirects the traffic of the pod once it is running.
+func (r *podRedirector) addPod(pod *corev1.Pod, portPairs []portPair) {
+	if pod.DeletionTimestamp != nil || pod.Status.Phase != corev1.PodRunning {
+		r.remove(pod.UID)
+		return
+	}
+	r.add(pod.UID, pod.Name, portPairs)
+}
+
+// remove stops redirecting the traffic of the pod.
+func (r *podRedirector) remove(uid types.UID) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if redirect, ok := r.pods[uid]; ok {
+		redirect.cancel()
+		delete(r.pods, uid)
+	}
+}
+
+// removeOthers stops redirecting the traffic of the pods that are not in keep.
+func (r *podRedirector) removeOthers(keep map[types.UID]bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for uid, redirect := range r.pods {
+		if !keep[uid] {
+			redirect.cancel()
+			delete(r.pods, uid)
+		}
+	}
+}
+
+// wait waits for the redirects to stop, once the context is done.
+func (r *podRedirector) wait() {
+	r.wg.Wait()
+}
+
+// runAllMatching redirects the traffic of all the pods that match the label selector, until the
+// user interrupts us.
+func (o *RedirOptions) runAllMatching() error {
+	ctx, cancel := context.WithCancel(o.ctx)
+	r := newPodRedirector(ctx, o)
+	defer r.wait()
+	defer cancel()
+
+	podclient := o.clientset.CoreV1().Pods(o.resultingContext.Namespace)
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = o.labelSelector
+			return podclient.List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = o.labelSelector
+			return podclient.Watch(ctx, options)
+		},
+	}
+	informer := cache.NewSharedIndexInformer(lw, &corev1.Pod{}, redirectResync, cache.Indexers{})
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			r.addPod(obj.(*corev1.Pod), o.portPairs)
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			r.addPod(obj.(*corev1.Pod), o.portPairs)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if pod, ok := obj.(*corev1.Pod); ok {
+				r.remove(pod.UID)
+			}
+		},
+	})
+	fmt.Fprintf(o.Out, "redirecting traffic from the pods matching %s, as they come and go\n", o.labelSelector)
+	informer.Run(ctx.Done())
+	return nil
+}
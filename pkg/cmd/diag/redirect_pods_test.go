@@ -0,0 +1,207 @@
+package diag
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// redirectCall is a call of the redirect func of a podRedirector.
+type redirectCall struct {
+	ctx       context.Context
+	podName   string
+	portPairs []portPair
+	setupDone func()
+}
+
+var _ = Describe("podRedirector", func() {
+	var (
+		r      *podRedirector
+		calls  chan redirectCall
+		errs   chan error
+		errOut *bytes.Buffer
+		cancel context.CancelFunc
+	)
+
+	BeforeEach(func() {
+		calls = make(chan redirectCall, 10)
+		errs = make(chan error, 10)
+		errOut = &bytes.Buffer{}
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		r = &podRedirector{
+			ctx: ctx,
+			// the redirects run until they are stopped, or fail with the next error of errs.
+			redirect: func(ctx context.Context, podName string, portPairs []portPair, setupDone func()) error {
+				calls <- redirectCall{ctx: ctx, podName: podName, portPairs: portPairs, setupDone: setupDone}
+				select {
+				case <-ctx.Done():
+					return nil
+				case err := <-errs:
+					return err
+				}
+			},
+			errOut: errOut,
+			setups: make(chan struct{}, 2),
+			pods:   map[types.UID]*podRedirect{},
+		}
+		DeferCleanup(func() {
+			cancel()
+			r.wait()
+		})
+	})
+
+	pods := func() int {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		return len(r.pods)
+	}
+
+	runningPod := func(uid types.UID, name string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{UID: uid, Name: name},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+	}
+
+	http := []portPair{{localPort: 8080, remotePort: 80}}
+
+	It("should redirect a pod once while its ports don't change", func() {
+		r.add("uid-1", "pod-1", http)
+		call := <-calls
+		Expect(call.podName).To(Equal("pod-1"))
+		Expect(call.portPairs).To(Equal(http))
+
+		r.add("uid-1", "pod-1", []portPair{{localPort: 8080, remotePort: 80}})
+		Consistently(calls).ShouldNot(Receive())
+		Expect(call.ctx.Err()).NotTo(HaveOccurred())
+	})
+
+	It("should restart the redirect when the ports of the pod change", func() {
+		r.add("uid-1", "pod-1", http)
+		first := <-calls
+
+		https := []portPair{{localPort: 8443, remotePort: 443}}
+		r.add("uid-1", "pod-1", https)
+		Eventually(first.ctx.Done()).Should(BeClosed())
+		var second redirectCall
+		Eventually(calls).Should(Receive(&second))
+		Expect(second.portPairs).To(Equal(https))
+		Expect(second.ctx.Err()).NotTo(HaveOccurred())
+		Expect(pods()).To(Equal(1))
+	})
+
+	It("should forget a failed redirect, so it is retried", func() {
+		errs <- fmt.Errorf("no manager")
+		r.add("uid-1", "pod-1", http)
+		<-calls
+		Eventually(pods).Should(BeZero())
+		Expect(errOut.String()).To(ContainSubstring("pod pod-1: no manager. retrying within"))
+
+		r.add("uid-1", "pod-1", http)
+		Eventually(calls).Should(Receive())
+		Expect(pods()).To(Equal(1))
+	})
+
+	It("should redirect running pods", func() {
+		r.addPod(runningPod("uid-1", "pod-1"), http)
+		Eventually(calls).Should(Receive())
+		Expect(pods()).To(Equal(1))
+	})
+
+	DescribeTable("should stop redirecting pods that are not running",
+		func(update func(pod *corev1.Pod)) {
+			pod := runningPod("uid-1", "pod-1")
+			r.addPod(pod, http)
+			call := <-calls
+
+			pod = pod.DeepCopy()
+			update(pod)
+			r.addPod(pod, http)
+			Eventually(call.ctx.Done()).Should(BeClosed())
+			Expect(pods()).To(BeZero())
+		},
+		Entry("succeeded", func(pod *corev1.Pod) { pod.Status.Phase = corev1.PodSucceeded }),
+		Entry("failed", func(pod *corev1.Pod) { pod.Status.Phase = corev1.PodFailed }),
+		Entry("deleted", func(pod *corev1.Pod) {
+			now := metav1.Now()
+			pod.DeletionTimestamp = &now
+		}),
+	)
+
+	It("should not redirect pending pods", func() {
+		pod := runningPod("uid-1", "pod-1")
+		pod.Status.Phase = corev1.PodPending
+		r.addPod(pod, http)
+		Consistently(calls).ShouldNot(Receive())
+	})
+
+	It("should stop redirecting removed pods", func() {
+		r.add("uid-1", "pod-1", http)
+		call := <-calls
+		r.remove("uid-1")
+		Eventually(call.ctx.Done()).Should(BeClosed())
+		Expect(pods()).To(BeZero())
+	})
+
+	It("should only keep the given pods", func() {
+		r.add("uid-1", "pod-1", http)
+		r.add("uid-2", "pod-2", http)
+		byName := map[string]redirectCall{}
+		for i := 0; i < 2; i++ {
+			call := <-calls
+			byName[call.podName] = call
+		}
+
+		r.removeOthers(map[types.UID]bool{"uid-2": true})
+		Eventually(byName["pod-1"].ctx.Done()).Should(BeClosed())
+		Expect(byName["pod-2"].ctx.Err()).NotTo(HaveOccurred())
+		Expect(pods()).To(Equal(1))
+
+		r.removeOthers(nil)
+		Eventually(byName["pod-2"].ctx.Done()).Should(BeClosed())
+		Expect(pods()).To(BeZero())
+	})
+
+	It("should bound the pods that are set up at once", func() {
+		r.add("uid-1", "pod-1", http)
+		r.add("uid-2", "pod-2", http)
+		r.add("uid-3", "pod-3", http)
+		first, second := <-calls, <-calls
+		Consistently(calls).ShouldNot(Receive())
+
+		// the first pod is set up, and only waits for connections.
+		first.setupDone()
+		var third redirectCall
+		Eventually(calls).Should(Receive(&third))
+		Expect([]string{first.podName, second.podName, third.podName}).To(ConsistOf("pod-1", "pod-2", "pod-3"))
+
+		// a pod that is removed while it is set up makes room too.
+		r.add("uid-4", "pod-4", http)
+		Consistently(calls).ShouldNot(Receive())
+		r.remove(types.UID(strings.Replace(second.podName, "pod", "uid", 1)))
+		var fourth redirectCall
+		Eventually(calls).Should(Receive(&fourth))
+		Expect(fourth.podName).To(Equal("pod-4"))
+	})
+
+	It("should not set up pods that are removed while they wait", func() {
+		r.add("uid-1", "pod-1", http)
+		r.add("uid-2", "pod-2", http)
+		<-calls
+		<-calls
+		r.add("uid-3", "pod-3", http)
+		r.remove("uid-3")
+		errs <- fmt.Errorf("no manager")
+		Consistently(calls).ShouldNot(Receive())
+		Expect(pods()).To(Equal(1))
+	})
+})
@@ -0,0 +1,154 @@
+package diag
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/samber/lo"
+	"github.com/solo-io/kdiag/pkg/manager"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// the ports redirected when none are given.
+const (
+	// the ports that processes in the pod listen on.
+	discoverListening = "listening"
+	// the ports declared in the containerPorts of the pod spec.
+	discoverDeclared = "declared"
+	// both.
+	discoverAll = "all"
+)
+
+// parsePortPair parses a remoteport[:localport] argument. The remote port may be the name of a port,
+// that is resolved in each pod or service.
+func parsePortPair(portString string) (portPair, error) {
+	parts := strings.Split(portString, ":")
+	var localString, remoteString string
+	if len(parts) == 1 {
+		localString = parts[0]
+		remoteString = parts[0]
+	} else if len(parts) == 2 {
+		localString = parts[1]
+		if localString == "" {
+			// support :5000
+			localString = "0"
+		}
+		remoteString = parts[0]
+	} else {
+		return portPair{}, fmt.Errorf("invalid port format '%s'", portString)
+	}
+
+	remotePort, err := strconv.ParseUint(remoteString, 10, 16)
+	if err != nil {
+		if len(validation.IsValidPortName(remoteString)) != 0 {
+			return portPair{}, fmt.Errorf("error parsing remote port '%s': %s", remoteString, err)
+		}
+		// a named port. the local port is the number of the port when not given.
+		pair := portPair{remoteName: remoteString, localFromRemote: len(parts) == 1}
+		if len(parts) == 2 {
+			localPort, err := strconv.ParseUint(localString, 10, 16)
+			if err != nil {
+				return portPair{}, fmt.Errorf("error parsing local port '%s': %s", localString, err)
+			}
+			pair.localPort = uint16(localPort)
+		}
+		return pair, nil
+	}
+	if remotePort == 0 {
+		return portPair{}, fmt.Errorf("remote port must be > 0")
+	}
+	localPort, err := strconv.ParseUint(localString, 10, 16)
+	if err != nil {
+		return portPair{}, fmt.Errorf("error parsing local port '%s': %s", localString, err)
+	}
+	return portPair{
+		localPort:  uint16(localPort),
+		remotePort: uint16(remotePort),
+	}, nil
+}
+
+// withRemotePort returns the pair with the number of its named port.
+func (p portPair) withRemotePort(port uint16) portPair {
+	p.remotePort = port
+	if p.localFromRemote {
+		p.localPort = port
+	}
+	return p
+}
+
+// resolvePodPorts resolves the named ports of the pairs to the container ports of the pod.
+func resolvePodPorts(pod *corev1.Pod, portPairs []portPair) ([]portPair, error) {
+	var resolved []portPair
+	for _, pair := range portPairs {
+		if pair.remoteName == "" {
+			resolved = append(resolved, pair)
+			continue
+		}
+		port, ok := containerPort(pod, pair.remoteName)
+		if !ok {
+			return nil, fmt.Errorf("pod %s has no tcp container port named %s", pod.Name, pair.remoteName)
+		}
+		resolved = append(resolved, pair.withRemotePort(port))
+	}
+	return resolved, nil
+}
+
+func containerPort(pod *corev1.Pod, name string) (uint16, bool) {
+	for _, c := range pod.Spec.Containers {
+		for _, port := range c.Ports {
+			if port.Name == name && isTCP(port.Protocol) {
+				return uint16(port.ContainerPort), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// declaredPorts returns the tcp container ports of the pod, sorted.
+func declaredPorts(pod *corev1.Pod) []uint16 {
+	var ports []uint16
+	for _, c := range pod.Spec.Containers {
+		for _, port := range c.Ports {
+			if isTCP(port.Protocol) {
+				ports = append(ports, uint16(port.ContainerPort))
+			}
+		}
+	}
+	ports = lo.Uniq(ports)
+	sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+	return ports
+}
+
+func isTCP(protocol corev1.Protocol) bool {
+	return protocol == "" || protocol == corev1.ProtocolTCP
+}
+
+// discoverPorts returns the ports to redirect in the pod when none are given.
+func (o *RedirOptions) discoverPorts(ctx context.Context, pod *corev1.Pod, mgrmgr manager.Manager) ([]uint16, error) {
+	filter := manager.ListenFilter{
+		IncludeLoopback: o.includeLoopback || o.local,
+		Pid:             uint64(o.discoverPid),
+		ProcessName:     o.discoverProcess,
+		ExcludePorts: lo.Map(o.excludePorts, func(port int, _ int) uint16 {
+			return uint16(port)
+		}),
+	}
+	var ports []uint16
+	if o.discover == discoverDeclared || o.discover == discoverAll {
+		ports = lo.Reject(declaredPorts(pod), func(port uint16, _ int) bool {
+			return lo.Contains(filter.ExcludePorts, port)
+		})
+	}
+	if o.discover == discoverListening || o.discover == discoverAll {
+		listening, err := mgrmgr.GetListeneningPorts(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		ports = lo.Uniq(append(ports, listening...))
+	}
+	return ports, nil
+}
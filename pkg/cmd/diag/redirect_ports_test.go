@@ -0,0 +1,117 @@
+package diag
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func podWithPorts(ports ...corev1.ContainerPort) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "app", Ports: ports}},
+		},
+	}
+}
+
+var _ = Describe("parsePortPair", func() {
+	DescribeTable("should parse valid ports",
+		func(arg string, expected portPair) {
+			Expect(parsePortPair(arg)).To(Equal(expected))
+		},
+		Entry("number", "80", portPair{localPort: 80, remotePort: 80}),
+		Entry("number and local port", "80:8080", portPair{localPort: 8080, remotePort: 80}),
+		Entry("number and any local port", "80:", portPair{localPort: 0, remotePort: 80}),
+		Entry("name", "http", portPair{remoteName: "http", localFromRemote: true}),
+		Entry("name and local port", "http:1234", portPair{remoteName: "http", localPort: 1234}),
+	)
+
+	DescribeTable("should reject invalid ports",
+		func(arg string, expected string) {
+			_, err := parsePortPair(arg)
+			Expect(err).To(MatchError(ContainSubstring(expected)))
+		},
+		Entry("out of range", "99999", "error parsing remote port '99999'"),
+		Entry("zero", "0", "remote port must be > 0"),
+		Entry("too many parts", "80:81:82", "invalid port format '80:81:82'"),
+		Entry("invalid local port", "80:x", "error parsing local port 'x'"),
+		Entry("invalid local port of a name", "http:x", "error parsing local port 'x'"),
+		Entry("invalid name", "-http", "error parsing remote port '-http'"),
+	)
+})
+
+var _ = Describe("withRemotePort", func() {
+	It("should use the remote port as the local port with localFromRemote", func() {
+		pair := portPair{remoteName: "http", localFromRemote: true}.withRemotePort(8080)
+		Expect(pair).To(Equal(portPair{remoteName: "http", localFromRemote: true, localPort: 8080, remotePort: 8080}))
+	})
+
+	It("should keep the local port without localFromRemote", func() {
+		pair := portPair{remoteName: "http", localPort: 1234}.withRemotePort(8080)
+		Expect(pair).To(Equal(portPair{remoteName: "http", localPort: 1234, remotePort: 8080}))
+	})
+})
+
+var _ = Describe("resolvePodPorts", func() {
+	pod := podWithPorts(
+		corev1.ContainerPort{Name: "dns", ContainerPort: 53, Protocol: corev1.ProtocolUDP},
+		corev1.ContainerPort{Name: "http", ContainerPort: 8080},
+		corev1.ContainerPort{Name: "dns-tcp", ContainerPort: 53, Protocol: corev1.ProtocolTCP},
+	)
+
+	It("should resolve named ports and keep numbered ones", func() {
+		resolved, err := resolvePodPorts(pod, []portPair{
+			{localPort: 80, remotePort: 80},
+			{remoteName: "http", localFromRemote: true},
+			{remoteName: "dns-tcp", localPort: 5353},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resolved).To(Equal([]portPair{
+			{localPort: 80, remotePort: 80},
+			{remoteName: "http", localFromRemote: true, localPort: 8080, remotePort: 8080},
+			{remoteName: "dns-tcp", localPort: 5353, remotePort: 53},
+		}))
+	})
+
+	DescribeTable("should fail on ports the pod doesn't have",
+		func(name string) {
+			_, err := resolvePodPorts(pod, []portPair{{remoteName: name, localFromRemote: true}})
+			Expect(err).To(MatchError("pod pod has no tcp container port named " + name))
+		},
+		Entry("missing", "grpc"),
+		Entry("udp", "dns"),
+	)
+})
+
+var _ = Describe("declaredPorts", func() {
+	It("should return the sorted tcp ports of all the containers", func() {
+		pod := podWithPorts(
+			corev1.ContainerPort{ContainerPort: 9090},
+			corev1.ContainerPort{ContainerPort: 53, Protocol: corev1.ProtocolUDP},
+			corev1.ContainerPort{ContainerPort: 8080, Protocol: corev1.ProtocolTCP},
+		)
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{
+			Name:  "sidecar",
+			Ports: []corev1.ContainerPort{{ContainerPort: 15090}, {ContainerPort: 8080}},
+		})
+		Expect(declaredPorts(pod)).To(Equal([]uint16{8080, 9090, 15090}))
+	})
+
+	It("should return nothing without tcp ports", func() {
+		pod := podWithPorts(corev1.ContainerPort{ContainerPort: 53, Protocol: corev1.ProtocolUDP})
+		Expect(declaredPorts(pod)).To(BeEmpty())
+	})
+})
+
+var _ = DescribeTable("isTCP",
+	func(protocol corev1.Protocol, expected bool) {
+		Expect(isTCP(protocol)).To(Equal(expected))
+	},
+	Entry("default", corev1.Protocol(""), true),
+	Entry("tcp", corev1.ProtocolTCP, true),
+	Entry("udp", corev1.ProtocolUDP, false),
+	Entry("sctp", corev1.ProtocolSCTP, false),
+)
@@ -0,0 +1,173 @@
+package diag
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/samber/lo"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// serviceName returns the name of the service of a svc/name argument.
+func serviceName(arg string) (string, bool) {
+	for _, prefix := range []string{"svc/", "service/", "services/"} {
+		if strings.HasPrefix(arg, prefix) {
+			return strings.TrimPrefix(arg, prefix), true
+		}
+	}
+	return "", false
+}
+
+// servicePortPair is a port of the service, and the local port its connections are redirected to.
+type servicePortPair struct {
+	port      corev1.ServicePort
+	localPort uint16
+}
+
+// servicePorts returns the ports of the service to redirect. The remote ports of the port pairs are
+// ports of the service, by number or by name. All the tcp ports of the service are redirected when
+// there are none.
+func servicePorts(svc *corev1.Service, portPairs []portPair) ([]servicePortPair, error) {
+	var ports []servicePortPair
+	if len(portPairs) == 0 {
+		for _, port := range svc.Spec.Ports {
+			if port.Protocol == corev1.ProtocolTCP {
+				ports = append(ports, servicePortPair{port: port, localPort: uint16(port.Port)})
+			}
+		}
+		if len(ports) == 0 {
+			return nil, fmt.Errorf("service %s has no tcp ports", svc.Name)
+		}
+		return ports, nil
+	}
+	for _, pair := range portPairs {
+		port, found := lo.Find(svc.Spec.Ports, func(port corev1.ServicePort) bool {
+			if port.Protocol != corev1.ProtocolTCP {
+				return false
+			}
+			if pair.remoteName != "" {
+				return port.Name == pair.remoteName
+			}
+			return port.Port == int32(pair.remotePort)
+		})
+		if !found && pair.remoteName != "" {
+			return nil, fmt.Errorf("service %s has no tcp port named %s", svc.Name, pair.remoteName)
+		}
+		if !found {
+			return nil, fmt.Errorf("service %s has no tcp port %d", svc.Name, pair.remotePort)
+		}
+		pair = pair.withRemotePort(uint16(port.Port))
+		ports = append(ports, servicePortPair{port: port, localPort: pair.localPort})
+	}
+	return ports, nil
+}
+
+// endpointPods returns the ready pods of the endpoint slices of the service, with the port pairs to
+// redirect in each of them. Named target ports may be different in each pod, so the ports of the pod
+// are the ports of its slice.
+func endpointPods(slices []*discoveryv1.EndpointSlice, ports []servicePortPair) map[types.UID]endpointPod {
+	pods := map[types.UID]endpointPod{}
+	for _, slice := range slices {
+		var portPairs []portPair
+		for _, port := range ports {
+			for _, endpointPort := range slice.Ports {
+				// a nil name is the unnamed port of the service, and a nil protocol is tcp.
+				name := ""
+				if endpointPort.Name != nil {
+					name = *endpointPort.Name
+				}
+				if endpointPort.Port == nil || name != port.port.Name {
+					continue
+				}
+				if endpointPort.Protocol == nil || *endpointPort.Protocol == corev1.ProtocolTCP {
+					portPairs = append(portPairs, portPair{localPort: port.localPort, remotePort: uint16(*endpointPort.Port)})
+				}
+			}
+		}
+		if len(portPairs) == 0 {
+			continue
+		}
+		for _, endpoint := range slice.Endpoints {
+			// a nil condition is ready.
+			if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+				continue
+			}
+			if endpoint.TargetRef == nil || endpoint.TargetRef.Kind != "Pod" {
+				continue
+			}
+			pods[endpoint.TargetRef.UID] = endpointPod{name: endpoint.TargetRef.Name, portPairs: portPairs}
+		}
+	}
+	return pods
+}
+
+type endpointPod struct {
+	name      string
+	portPairs []portPair
+}
+
+// runService redirects the traffic of the pods that back the service, until the user interrupts
+// us. The pods are tracked with the endpoint slices of the service, so they follow rollouts and scaling.
+func (o *RedirOptions) runService() error {
+	namespace := o.resultingContext.Namespace
+	svc, err := o.clientset.CoreV1().Services(namespace).Get(o.ctx, o.serviceName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get service: %w", err)
+	}
+	ports, err := servicePorts(svc, o.portPairs)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(o.ctx)
+	r := newPodRedirector(ctx, o)
+	defer r.wait()
+	defer cancel()
+
+	// a service has many endpoint slices, e.g. one per address type, so the pods of all of them are
+	// redirected.
+	slicesclient := o.clientset.DiscoveryV1().EndpointSlices(namespace)
+	labelSelector := labels.Set{discoveryv1.LabelServiceName: o.serviceName}.String()
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = labelSelector
+			return slicesclient.List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = labelSelector
+			return slicesclient.Watch(ctx, options)
+		},
+	}
+	informer := cache.NewSharedIndexInformer(lw, &discoveryv1.EndpointSlice{}, redirectResync, cache.Indexers{})
+	// the store of the informer is up to date when the handlers are called.
+	update := func(interface{}) {
+		slices := lo.Map(informer.GetStore().List(), func(obj interface{}, _ int) *discoveryv1.EndpointSlice {
+			return obj.(*discoveryv1.EndpointSlice)
+		})
+		pods := endpointPods(slices, ports)
+		keep := map[types.UID]bool{}
+		for uid, pod := range pods {
+			keep[uid] = true
+			r.add(uid, pod.name, pod.portPairs)
+		}
+		r.removeOthers(keep)
+	}
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: update,
+		UpdateFunc: func(_, obj interface{}) {
+			update(obj)
+		},
+		DeleteFunc: update,
+	})
+	fmt.Fprintf(o.Out, "redirecting traffic from the pods of service %s, as they come and go\n", o.serviceName)
+	informer.Run(ctx.Done())
+	return nil
+}
@@ -0,0 +1,201 @@
+package diag
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+var _ = DescribeTable("serviceName",
+	func(arg, expected string, ok bool) {
+		name, found := serviceName(arg)
+		Expect(found).To(Equal(ok))
+		Expect(name).To(Equal(expected))
+	},
+	Entry("svc", "svc/reviews", "reviews", true),
+	Entry("service", "service/reviews", "reviews", true),
+	Entry("services", "services/reviews", "reviews", true),
+	Entry("port", "8080", "", false),
+)
+
+var _ = Describe("servicePorts", func() {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "reviews"},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{
+				{Name: "http", Port: 80, TargetPort: intstr.FromString("http"), Protocol: corev1.ProtocolTCP},
+				{Name: "dns", Port: 53, Protocol: corev1.ProtocolUDP},
+				{Name: "grpc", Port: 9080, TargetPort: intstr.FromInt(9081), Protocol: corev1.ProtocolTCP},
+			},
+		},
+	}
+
+	It("should redirect all the tcp ports without port pairs", func() {
+		ports, err := servicePorts(svc, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ports).To(Equal([]servicePortPair{
+			{port: svc.Spec.Ports[0], localPort: 80},
+			{port: svc.Spec.Ports[2], localPort: 9080},
+		}))
+	})
+
+	It("should select the ports by number or name", func() {
+		ports, err := servicePorts(svc, []portPair{
+			{remotePort: 9080, localPort: 19080},
+			{remoteName: "http", localFromRemote: true},
+			{remoteName: "http", localPort: 8080},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ports).To(Equal([]servicePortPair{
+			{port: svc.Spec.Ports[2], localPort: 19080},
+			{port: svc.Spec.Ports[0], localPort: 80},
+			{port: svc.Spec.Ports[0], localPort: 8080},
+		}))
+	})
+
+	DescribeTable("should fail on ports the service doesn't have",
+		func(pair portPair, expected string) {
+			_, err := servicePorts(svc, []portPair{pair})
+			Expect(err).To(MatchError(expected))
+		},
+		Entry("number", portPair{remotePort: 8080, localPort: 8080}, "service reviews has no tcp port 8080"),
+		Entry("name", portPair{remoteName: "metrics", localFromRemote: true}, "service reviews has no tcp port named metrics"),
+		Entry("udp number", portPair{remotePort: 53, localPort: 53}, "service reviews has no tcp port 53"),
+		Entry("udp name", portPair{remoteName: "dns", localFromRemote: true}, "service reviews has no tcp port named dns"),
+	)
+
+	It("should fail on a service without tcp ports", func() {
+		udp := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "dns"},
+			Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "dns", Port: 53, Protocol: corev1.ProtocolUDP}}},
+		}
+		_, err := servicePorts(udp, nil)
+		Expect(err).To(MatchError("service dns has no tcp ports"))
+	})
+})
+
+var _ = Describe("endpointPods", func() {
+	podEndpoint := func(uid types.UID, name string) discoveryv1.Endpoint {
+		return discoveryv1.Endpoint{TargetRef: &corev1.ObjectReference{Kind: "Pod", UID: uid, Name: name}}
+	}
+	endpointPort := func(name string, port int32) discoveryv1.EndpointPort {
+		tcp := corev1.ProtocolTCP
+		return discoveryv1.EndpointPort{Name: &name, Port: &port, Protocol: &tcp}
+	}
+	ports := []servicePortPair{
+		{port: corev1.ServicePort{Name: "http", Port: 80}, localPort: 8080},
+		{port: corev1.ServicePort{Name: "grpc", Port: 9080}, localPort: 9080},
+	}
+
+	It("should resolve the ports of each slice", func() {
+		// a named target port may be a different container port in each version of the pods.
+		slices := []*discoveryv1.EndpointSlice{
+			{
+				Endpoints: []discoveryv1.Endpoint{podEndpoint("uid-1", "v1-a"), podEndpoint("uid-2", "v1-b")},
+				Ports:     []discoveryv1.EndpointPort{endpointPort("http", 8000), endpointPort("grpc", 9081)},
+			},
+			{
+				Endpoints: []discoveryv1.Endpoint{podEndpoint("uid-3", "v2")},
+				Ports:     []discoveryv1.EndpointPort{endpointPort("http", 8001), endpointPort("grpc", 9081)},
+			},
+		}
+		v1Ports := []portPair{{localPort: 8080, remotePort: 8000}, {localPort: 9080, remotePort: 9081}}
+		Expect(endpointPods(slices, ports)).To(Equal(map[types.UID]endpointPod{
+			"uid-1": {name: "v1-a", portPairs: v1Ports},
+			"uid-2": {name: "v1-b", portPairs: v1Ports},
+			"uid-3": {name: "v2", portPairs: []portPair{{localPort: 8080, remotePort: 8001}, {localPort: 9080, remotePort: 9081}}},
+		}))
+	})
+
+	It("should merge the slices of both address types", func() {
+		endpoints := []discoveryv1.Endpoint{podEndpoint("uid-1", "pod")}
+		slicePorts := []discoveryv1.EndpointPort{endpointPort("http", 8000)}
+		slices := []*discoveryv1.EndpointSlice{
+			{AddressType: discoveryv1.AddressTypeIPv4, Endpoints: endpoints, Ports: slicePorts},
+			{AddressType: discoveryv1.AddressTypeIPv6, Endpoints: endpoints, Ports: slicePorts},
+		}
+		Expect(endpointPods(slices, ports)).To(Equal(map[types.UID]endpointPod{
+			"uid-1": {name: "pod", portPairs: []portPair{{localPort: 8080, remotePort: 8000}}},
+		}))
+	})
+
+	It("should use the unnamed port of the service", func() {
+		var port int32 = 8000
+		slices := []*discoveryv1.EndpointSlice{{
+			Endpoints: []discoveryv1.Endpoint{podEndpoint("uid-1", "pod")},
+			// the name and protocol of the only port of a service may be nil.
+			Ports: []discoveryv1.EndpointPort{{Port: &port}},
+		}}
+		unnamed := []servicePortPair{{port: corev1.ServicePort{Port: 80}, localPort: 8080}}
+		Expect(endpointPods(slices, unnamed)).To(Equal(map[types.UID]endpointPod{
+			"uid-1": {name: "pod", portPairs: []portPair{{localPort: 8080, remotePort: 8000}}},
+		}))
+	})
+
+	It("should skip endpoints that are not ready pods", func() {
+		notReady := podEndpoint("uid-2", "not-ready")
+		notReady.Conditions.Ready = new(bool)
+		ready := podEndpoint("uid-3", "ready")
+		ready.Conditions.Ready = new(bool)
+		*ready.Conditions.Ready = true
+		slices := []*discoveryv1.EndpointSlice{{
+			Endpoints: []discoveryv1.Endpoint{
+				{Addresses: []string{"10.0.0.1"}},
+				{Addresses: []string{"10.0.0.2"}, TargetRef: &corev1.ObjectReference{Kind: "Node", UID: "node-uid", Name: "node"}},
+				podEndpoint("uid-1", "pod"),
+				notReady,
+				ready,
+			},
+			Ports: []discoveryv1.EndpointPort{endpointPort("http", 8000)},
+		}}
+		httpPorts := []portPair{{localPort: 8080, remotePort: 8000}}
+		Expect(endpointPods(slices, ports)).To(Equal(map[types.UID]endpointPod{
+			"uid-1": {name: "pod", portPairs: httpPorts},
+			"uid-3": {name: "ready", portPairs: httpPorts},
+		}))
+	})
+
+	It("should skip slices without the ports", func() {
+		udp := corev1.ProtocolUDP
+		udpPort := endpointPort("http", 8000)
+		udpPort.Protocol = &udp
+		slices := []*discoveryv1.EndpointSlice{{
+			Endpoints: []discoveryv1.Endpoint{podEndpoint("uid-1", "pod")},
+			Ports:     []discoveryv1.EndpointPort{endpointPort("metrics", 15090), udpPort},
+		}}
+		Expect(endpointPods(slices, ports)).To(BeEmpty())
+	})
+})
+
+var _ = Describe("RedirOptions.Validate", func() {
+	serviceOptions := func() *RedirOptions {
+		return &RedirOptions{
+			DiagOptions:    &DiagOptions{pullPolicyString: string(corev1.PullIfNotPresent)},
+			serviceName:    "reviews",
+			discover:       discoverListening,
+			maxConcurrency: 10,
+		}
+	}
+
+	It("should accept a service", func() {
+		Expect(serviceOptions().Validate()).To(Succeed())
+	})
+
+	DescribeTable("should reject discovery flags with a service",
+		func(update func(o *RedirOptions)) {
+			o := serviceOptions()
+			update(o)
+			Expect(o.Validate()).To(MatchError(ContainSubstring("a service can't be used with --discover")))
+		},
+		Entry("--discover", func(o *RedirOptions) { o.discover = discoverDeclared }),
+		Entry("--include-loopback", func(o *RedirOptions) { o.includeLoopback = true }),
+		Entry("--discover-pid", func(o *RedirOptions) { o.discoverPid = 1 }),
+		Entry("--discover-process", func(o *RedirOptions) { o.discoverProcess = "envoy" }),
+		Entry("--exclude-ports", func(o *RedirOptions) { o.excludePorts = []int{15090} }),
+	)
+})
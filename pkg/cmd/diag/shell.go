@@ -1,14 +1,18 @@
 package diag
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"sync"
 
+	"github.com/fatih/color"
+	"github.com/samber/lo"
+	"github.com/solo-io/kdiag/pkg/logs"
 	"github.com/solo-io/kdiag/pkg/manager"
 	"github.com/spf13/cobra"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/tools/remotecommand"
-	utilexec "k8s.io/client-go/util/exec"
 	"k8s.io/kubectl/pkg/util/term"
 )
 
@@ -17,8 +21,6 @@ var (
 	Start a shell to a pod. Unlike regular "kubectl exec" this command works even in distroless and
 	scratch containers. It does so by using standalone busybox ash binary as the shell. This means
 	that the shell is more limited, but works on any container as long as "/proc" is mounted.
-	Note that this command needs linux kernel >= 5.3 to work. though this requirement may be relaxed
-	in the future if needed.
 
 	For example:
 
@@ -30,17 +32,61 @@ var (
 
 	Start a shell targeting the istio-proxy container in the productpage pod.
 
-	Note: a container is only created once, and may have been created from the previous commands. so specifying
-	a different target the second time will have no effect.
+	The exit code of the command run in the pod is used as the exit code of this command. If the command
+	could not be run in the pod (e.g. the connection to the pod failed), the exit code is %[2]d.
+
+	By default the shell enters the namespaces of pid 1. If the pod shares its process namespace, or
+	the container runs more than one process, you can select the process to enter by name or by pid:
+
+	%[1]s -l app=productpage -n bookinfo -t istio-proxy shell --target-process envoy
+
+	By default the shell runs as root in "/", with an empty environment. Use --as-target to run it with
+	the environment, working directory, user and groups of the target process, so it sees what the app
+	sees. The shell then gets the capabilities a program started by the target would get: none for
+	non-root users, and at most the bounding set of the target for root. Add --keep-caps to use the
+	capabilities of the target process itself:
+
+	%[1]s -l app=productpage -n bookinfo -t istio-proxy shell --as-target --keep-caps -- -c 'env | grep ISTIO_META'
+
+	Run a command in all the pods matching a label, to compare them. The output is prefixed with the pod
+	name, and the exit code is the highest exit code across the pods:
+
+	%[1]s -l app=productpage -n bookinfo -t istio-proxy shell --all-matching -- -c "cat /etc/resolv.conf"
+
+	Record the session's input and output in asciicast v2 format, to replay it with asciinema. The recording is saved
+	locally, and can also be uploaded to a config map in the pod's namespace or a file in the manager
+	container. Pods annotated with %[3]s (e.g. "local,configmap") are always recorded:
+
+	%[1]s -l app=productpage -n bookinfo -t istio-proxy shell --record --record-upload configmap
+
+	Note: ephemeral containers can't be changed once created. If the pod already has a manager container
+	that targets a different container, a new manager container is created for the requested target.
 `
 )
 
+const (
+	// the shell of the debug image, that is run in the namespaces of the target.
+	debugImageShell = "/usr/local/bin/ash"
+	// the shell of the manager container, with --debug-shell.
+	debugContainerShell = "/bin/bash"
+)
+
 // ShellOptions provides information required to update
 // the current context on a user's KUBECONFIG
 type ShellOptions struct {
 	*DiagOptions
+	targetProcessOptions
+	recordOptions
 	debugShell bool
+	asTarget   bool
+	keepCaps   bool
 	args       []string
+
+	allMatching bool
+	// the number of pods the command runs in at once with allMatching.
+	maxConcurrency int
+	noColor        bool
+	pods           []string
 }
 
 // NewShellOptions provides an instance of ShellOptions with default values
@@ -57,7 +103,7 @@ func NewCmdShell(diagOptions *DiagOptions) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:          "shell",
 		Short:        "start a debug shell to the pod with an ephemeral container",
-		Example:      fmt.Sprintf(shellExample, CommandName()),
+		Example:      fmt.Sprintf(shellExample, CommandName(), TransportErrorExitCode, RecordAnnotation),
 		SilenceUsage: true,
 		RunE: func(c *cobra.Command, args []string) error {
 			if err := o.Complete(c, args); err != nil {
@@ -74,6 +120,13 @@ func NewCmdShell(diagOptions *DiagOptions) *cobra.Command {
 		},
 	}
 	AddSinglePodFlags(cmd, o.DiagOptions)
+	AddTargetProcessFlags(cmd, &o.targetProcessOptions)
+	AddRecordFlags(cmd, &o.recordOptions)
+	cmd.Flags().BoolVar(&o.asTarget, "as-target", false, "run the shell with the environment, working directory, user and groups of the target process")
+	cmd.Flags().BoolVar(&o.keepCaps, "keep-caps", false, "run the shell with the capabilities of the target process, rather than the ones a program it starts would get. requires --as-target")
+	cmd.Flags().BoolVar(&o.allMatching, "all-matching", false, "run the command in all the pods matching the label selector, instead of one of them")
+	cmd.Flags().IntVar(&o.maxConcurrency, "max-concurrency", logs.DefaultMaxRequests, "maximum number of pods to run the command in at once with --all-matching, to not flood the api server")
+	cmd.Flags().BoolVar(&o.noColor, "no-color", false, "Disable color output with --all-matching")
 	cmd.Flags().BoolVar(&o.debugShell, "debug-shell", false, "start a debug shell in the ephemeral container instead of the pod's container")
 	// hidden as it used for dev purposes.
 	cmd.Flags().MarkHidden("debug-shell")
@@ -90,113 +143,205 @@ func (o *ShellOptions) Complete(cmd *cobra.Command, args []string) error {
 
 // Validate ensures that all required arguments and flag values are provided
 func (o *ShellOptions) Validate() error {
-	return ValidateSinglePodFlags(o.DiagOptions)
+	if o.keepCaps && !o.asTarget {
+		return fmt.Errorf("--keep-caps requires --as-target")
+	}
+	if err := o.targetProcessOptions.Validate(); err != nil {
+		return err
+	}
+	if err := o.recordOptions.Validate(); err != nil {
+		return err
+	}
+	if o.record && o.allMatching {
+		return fmt.Errorf("--record can't be used with --all-matching")
+	}
+	if o.maxConcurrency <= 0 {
+		return fmt.Errorf("invalid max-concurrency: %d", o.maxConcurrency)
+	}
+	if err := ValidateSinglePodFlags(o.DiagOptions); err != nil {
+		return err
+	}
+
+	if o.allMatching {
+		if o.labelSelector == "" {
+			return fmt.Errorf("--all-matching requires a label selector")
+		}
+		// without a tty or stdin, an interactive shell would just exit.
+		if len(o.args) == 0 {
+			return fmt.Errorf("--all-matching requires a command to run, e.g. -- -c \"cat /etc/resolv.conf\"")
+		}
+		pods, err := o.listLabeledPods()
+		if err != nil {
+			return err
+		}
+		o.pods = lo.Map(pods, func(p corev1.Pod, _ int) string {
+			return p.Name
+		})
+	}
+	return nil
 }
 
 // Run lists all available namespaces on a user's KUBECONFIG or updates the
 // current context based on a provided namespace.
 func (o *ShellOptions) Run() error {
+	if o.allMatching {
+		return o.runAllMatching()
+	}
 
-	// exec!
-	mgr := manager.NewEmephemeralContainerManager(o.clientset.CoreV1())
-
-	_, err := mgr.EnsurePodManaged(o.ctx, o.resultingContext.Namespace, o.podName, o.dbgContainerImage, o.targetContainerName, o.pullPolicy)
+	// like with --all-matching, failing to start the session in the pod is a transport error.
+	podObj, containerName, cmd, err := o.prepare(o.podName, o.ErrOut)
 	if err != nil {
-		return fmt.Errorf("failed to ensure pod managed: %v", err)
+		return &ExitError{Code: TransportErrorExitCode, Err: err}
+	}
+	if err := o.applyPolicy(podObj); err != nil {
+		return &ExitError{Code: TransportErrorExitCode, Err: err}
 	}
-
-	execRequest := o.clientset.CoreV1().RESTClient().Post().
-		Resource("pods").
-		Name(o.podName).
-		Namespace(o.resultingContext.Namespace).
-		SubResource("exec")
 
 	tty := isTty(o.IOStreams.Out)
+	var size *remotecommand.TerminalSize
 	var sizeQueue remotecommand.TerminalSizeQueue
 
 	safe := func(fn term.SafeFunc) error {
 		return fn()
 	}
 
+	// both stdout and stderr go over stdout when tty is true
+	stderr := o.ErrOut
 	if tty {
 		t := o.SetupTTY()
+		size = t.GetSize()
 		// this call spawns a goroutine to monitor/update the terminal size
-		sizeQueue = t.MonitorSize(t.GetSize())
+		sizeQueue = t.MonitorSize(size)
 		safe = t.Safe
+		stderr = nil
 	}
 
-	// unset p.Err if it was previously set because both stdout and stderr go over p.Out when tty is
-	// true
-	o.ErrOut = nil
-
-	// run ASH in the namespaces of pid 1, as pid 1 belongs to the target container.
-	cmd := []string{"/usr/local/bin/enter", "1", "/usr/local/bin/ash"}
-	if o.debugShell {
-		cmd = []string{"/bin/bash"}
+	streamOpts := remotecommand.StreamOptions{
+		Stdin:             o.In,
+		Stdout:            o.Out,
+		Stderr:            stderr,
+		Tty:               tty,
+		TerminalSizeQueue: sizeQueue,
+	}
+	var rec *sessionRecording
+	if o.record {
+		shell := debugImageShell
+		if o.debugShell {
+			shell = debugContainerShell
+		}
+		rec, err = o.startRecording(o.podName, "kdiag shell "+o.podName, shell, cmd, size, &streamOpts)
+		if err != nil {
+			return &ExitError{Code: TransportErrorExitCode, Err: err}
+		}
 	}
-	cmd = append(cmd, o.args...)
-
-	execRequest.VersionedParams(&corev1.PodExecOptions{
-		Container: mgr.ContainerName(),
-		Command:   cmd,
-		Stdin:     true,
-		Stdout:    true,
-		Stderr:    o.ErrOut != nil,
-		TTY:       tty,
-	}, scheme.ParameterCodec)
 
-	exec, err := remotecommand.NewSPDYExecutor(o.restConfig, "POST", execRequest.URL())
-	if err != nil {
-		return fmt.Errorf("failed to create executor: %v", err)
+	// keep the output clean when used from scripts.
+	if tty {
+		fmt.Fprintln(o.Out, "Connecting to pod...")
 	}
-	fmt.Fprintln(o.Out, "Connecting to pod...")
 
 	fn := func() error {
-		err = exec.Stream(remotecommand.StreamOptions{
-			Stdin:             o.In,
-			Stdout:            o.Out,
-			Stderr:            o.ErrOut,
-			Tty:               tty,
-			TerminalSizeQueue: sizeQueue,
-		})
+		return o.streamInContainer(o.podName, containerName, cmd, streamOpts)
+	}
 
-		var exitCode = 0
-		if err != nil {
-			if exitErr, ok := err.(utilexec.ExitError); ok && exitErr.Exited() {
-				exitCode = exitErr.ExitStatus()
-				err = nil
+	err = remoteExitError(safe(fn))
+	if rec != nil {
+		if recErr := o.finishRecording(rec, &o.recordOptions, o.podName, containerName); recErr != nil {
+			recErr = fmt.Errorf("failed to save the session recording: %w", recErr)
+			if err == nil {
+				return recErr
 			}
+			// the session's own error is returned, so report this one here.
+			fmt.Fprintln(o.ErrOut, recErr)
 		}
-		// TODO:
-		_ = exitCode
-		return err
+	}
+	return err
+}
+
+// prepare makes sure the pod is managed, and returns it with the manager container and the command
+// to run in it. Warnings are written to errOut.
+func (o *ShellOptions) prepare(podName string, errOut io.Writer) (*corev1.Pod, string, []string, error) {
+	mgr := manager.NewEmephemeralContainerManager(o.clientset.CoreV1())
+
+	podObj, containerName, err := mgr.EnsurePodManaged(o.ctx, o.resultingContext.Namespace, podName, o.dbgContainerImage, o.targetContainerName, o.pullPolicy)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("failed to ensure pod managed: %v", err)
 	}
 
-	if err := safe(fn); err != nil {
-		return err
+	if o.debugShell {
+		return podObj, containerName, append([]string{debugContainerShell}, o.args...), nil
 	}
 
+	pid, err := o.resolveTargetPid(podObj, containerName, &o.targetProcessOptions, errOut)
 	if err != nil {
-		return fmt.Errorf("failed to execute command: %v", err)
+		return nil, "", nil, err
 	}
 
-	return nil
+	// run ASH in the namespaces of the target pid. unless the pod shares its process namespace,
+	// pid 1 belongs to the target container.
+	cmd := append(enterCommand(pid, enterOptions{asTarget: o.asTarget, keepCaps: o.keepCaps}), debugImageShell)
+	return podObj, containerName, append(cmd, o.args...), nil
 }
 
-func (o *ShellOptions) SetupTTY() term.TTY {
-	t := term.TTY{
-		Parent: nil,
-		Out:    o.Out,
-		In:     o.In,
-		Raw:    true,
+// runAllMatching runs the command in all the pods concurrently, and prints their output prefixed with
+// the pod name. The exit code is the highest exit code of the command across the pods.
+func (o *ShellOptions) runAllMatching() error {
+	if colorNotAvailable(o.IOStreams.Out) || o.noColor {
+		color.NoColor = true
 	}
 
-	t.In = o.In
-
-	if !t.IsTerminalIn() {
-		fmt.Fprintln(o.ErrOut, "Unable to use a TTY - input is not a terminal or the right kind of file")
-		return t
+	var printer logs.PrefixPrinter
+	errs := make([]error, len(o.pods))
+	var wg sync.WaitGroup
+	// each pod needs a few requests to the api server, so only so many run at once.
+	sessions := make(chan struct{}, o.maxConcurrency)
+	for i, podName := range o.pods {
+		wg.Add(1)
+		sessions <- struct{}{}
+		go func(i int, podName string) {
+			defer wg.Done()
+			defer func() { <-sessions }()
+			stdout := printer.Writer(o.Out, i, podName)
+			stderr := printer.Writer(o.ErrOut, i, podName)
+			defer stdout.Flush()
+			defer stderr.Flush()
+
+			podObj, containerName, cmd, err := o.prepare(podName, stderr)
+			if err != nil {
+				errs[i] = &ExitError{Code: TransportErrorExitCode, Err: err}
+				return
+			}
+			// broadcast sessions can't be recorded. don't bypass the policy.
+			if _, ok := podObj.Annotations[RecordAnnotation]; ok {
+				errs[i] = &ExitError{Code: TransportErrorExitCode, Err: fmt.Errorf("pod requires session recording, which is not supported with --all-matching")}
+				return
+			}
+			errs[i] = remoteExitError(o.streamInContainer(podName, containerName, cmd, remotecommand.StreamOptions{
+				Stdout: stdout,
+				Stderr: stderr,
+			}))
+		}(i, podName)
 	}
+	wg.Wait()
 
-	return t
+	failed := 0
+	code := 0
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		failed++
+		fmt.Fprintf(o.ErrOut, "pod %s: %v\n", o.pods[i], err)
+		var exitErr *ExitError
+		if errors.As(err, &exitErr) && exitErr.Code > code {
+			code = exitErr.Code
+		}
+	}
+	if failed == 0 {
+		return nil
+	}
+	return &ExitError{
+		Code: code,
+		Err:  fmt.Errorf("command failed in %d of %d pods", failed, len(o.pods)),
+	}
 }
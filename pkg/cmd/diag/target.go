@@ -0,0 +1,125 @@
+package diag
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/samber/lo"
+	pb "github.com/solo-io/kdiag/pkg/api/kdiag"
+	"github.com/solo-io/kdiag/pkg/enter"
+	"github.com/solo-io/kdiag/pkg/manager"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// enterOptions are passed to the enter subcommand of the manager.
+type enterOptions struct {
+	// namespaces to enter. all of them when empty.
+	namespaces []enter.Namespace
+	// asTarget runs the binary with the environment, cwd and credentials of the target process.
+	asTarget bool
+	// keepCaps also applies the capabilities of the target process. requires asTarget.
+	keepCaps bool
+}
+
+// enterCommand returns the command that runs a binary from the debug image in the namespaces of the
+// process with the given pid. The binary and its arguments should be appended to it.
+func enterCommand(pid uint64, opts enterOptions) []string {
+	cmd := []string{"/usr/local/bin/manager", "enter"}
+	if len(opts.namespaces) != 0 {
+		cmd = append(cmd, "--ns", enter.JoinNamespaces(opts.namespaces))
+	}
+	if opts.asTarget {
+		cmd = append(cmd, "--as-target")
+	}
+	if opts.keepCaps {
+		cmd = append(cmd, "--keep-caps")
+	}
+	return append(cmd, strconv.FormatUint(pid, 10))
+}
+
+// targetProcessOptions selects the process in the target container whose namespaces we enter.
+type targetProcessOptions struct {
+	targetPid     int
+	targetProcess string
+}
+
+func AddTargetProcessFlags(cmd *cobra.Command, o *targetProcessOptions) {
+	cmd.Flags().IntVar(&o.targetPid, "target-pid", 0, "pid of the process whose namespaces to enter. defaults to 1")
+	cmd.Flags().StringVar(&o.targetProcess, "target-process", "", "name of the process whose namespaces to enter. can't be used with --target-pid")
+}
+
+func (o *targetProcessOptions) Validate() error {
+	if o.targetPid < 0 {
+		return fmt.Errorf("invalid target-pid: %d", o.targetPid)
+	}
+	if o.targetPid != 0 && o.targetProcess != "" {
+		return fmt.Errorf("only one of target-pid,target-process can be provided")
+	}
+	return nil
+}
+
+// resolveTargetPid returns the pid whose namespaces commands should enter. It asks the manager
+// for the process list when selecting by process name, or when the pod shares its process namespace
+// so we can warn if the pid doesn't belong to the target container. Warnings are written to errOut.
+func (o *DiagOptions) resolveTargetPid(podObj *corev1.Pod, managerContainer string, t *targetProcessOptions, errOut io.Writer) (uint64, error) {
+	pid := uint64(1)
+	if t.targetPid != 0 {
+		pid = uint64(t.targetPid)
+	}
+	sharesProcessNamespace := podObj.Spec.ShareProcessNamespace != nil && *podObj.Spec.ShareProcessNamespace
+	if t.targetProcess == "" && !sharesProcessNamespace {
+		return pid, nil
+	}
+
+	// don't clutter the command's output with port-forward messages.
+	mgrmgr, err := manager.NewManager(o.ctx, o.restConfig, o.clientset, io.Discard, errOut, podObj.Name, o.resultingContext.Namespace, managerContainer)
+	if err != nil {
+		return 0, err
+	}
+	defer mgrmgr.Close()
+
+	processes, err := mgrmgr.Processes(o.ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	if t.targetProcess != "" {
+		matches := lo.Filter(processes, func(p *pb.PsResponse_ProcessInfo, _ int) bool {
+			return p.Name == t.targetProcess
+		})
+		switch len(matches) {
+		case 0:
+			return 0, fmt.Errorf("no process named %s found in pod %s", t.targetProcess, podObj.Name)
+		case 1:
+			pid = matches[0].Pid
+		default:
+			pids := lo.Map(matches, func(p *pb.PsResponse_ProcessInfo, _ int) uint64 {
+				return p.Pid
+			})
+			return 0, fmt.Errorf("multiple processes named %s found in pod %s (pids %v). use --target-pid to select one", t.targetProcess, podObj.Name, pids)
+		}
+	}
+
+	if sharesProcessNamespace {
+		target := o.targetContainerName
+		if target == "" {
+			target = podObj.Spec.Containers[0].Name
+		}
+		proc, found := lo.Find(processes, func(p *pb.PsResponse_ProcessInfo) bool {
+			return p.Pid == pid
+		})
+		if !found {
+			return 0, fmt.Errorf("no process with pid %d found in pod %s", pid, podObj.Name)
+		}
+		container := manager.ContainerNameForID(podObj, proc.ContainerId)
+		if container == "" {
+			fmt.Fprintf(errOut, "warning: pod shares its process namespace, and pid %d (%s) does not belong to the target container %s. use --target-pid or --target-process to select a process in it\n", pid, proc.Name, target)
+		} else if container != target {
+			fmt.Fprintf(errOut, "warning: pod shares its process namespace, and pid %d (%s) belongs to container %s, not the target container %s. use --target-pid or --target-process to select a process in it\n", pid, proc.Name, container, target)
+		}
+	}
+
+	return pid, nil
+}
@@ -0,0 +1,68 @@
+package srv
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"github.com/solo-io/kdiag/pkg/enter"
+	"github.com/spf13/pflag"
+)
+
+const (
+	// exit codes, following the shell convention.
+	enterFailedExitCode   = 126
+	enterNotFoundExitCode = 127
+)
+
+// runEnter runs a binary from our image in the namespaces of another process:
+//
+//	manager enter [--ns mnt,net,pid,ipc,uts,cgroup] [--as-target [--keep-caps]] <pid> <binary> [args...]
+//
+// It only returns if entering failed.
+func runEnter(args []string) int {
+	flags := pflag.NewFlagSet("enter", pflag.ContinueOnError)
+	// everything after the pid belongs to the binary.
+	flags.SetInterspersed(false)
+	nsString := flags.String("ns", enter.JoinNamespaces(enter.AllNamespaces), "comma separated list of namespaces to enter")
+	asTarget := flags.Bool("as-target", false, "run with the environment, working directory, user and groups of the target")
+	keepCaps := flags.Bool("keep-caps", false, "also run with the capabilities of the target. requires --as-target")
+	if err := flags.Parse(args); err != nil {
+		return enterFailedExitCode
+	}
+	if flags.NArg() < 2 {
+		fmt.Fprintln(os.Stderr, "usage: enter [--ns namespaces] [--as-target [--keep-caps]] <pid> <binary> [args...]")
+		return enterFailedExitCode
+	}
+
+	pid, err := strconv.Atoi(flags.Arg(0))
+	if err != nil || pid <= 0 {
+		fmt.Fprintf(os.Stderr, "enter: invalid pid %s\n", flags.Arg(0))
+		return enterFailedExitCode
+	}
+	if *keepCaps && !*asTarget {
+		fmt.Fprintln(os.Stderr, "enter: --keep-caps requires --as-target")
+		return enterFailedExitCode
+	}
+	namespaces, err := enter.ParseNamespaces(*nsString)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "enter: %v\n", err)
+		return enterFailedExitCode
+	}
+
+	err = enter.Exec(enter.Options{
+		Pid:        pid,
+		Namespaces: namespaces,
+		Bin:        flags.Arg(1),
+		Args:       flags.Args()[1:],
+		AsTarget:   *asTarget,
+		KeepCaps:   *keepCaps,
+	})
+	fmt.Fprintf(os.Stderr, "enter: %v\n", err)
+	if errors.Is(err, exec.ErrNotFound) {
+		return enterNotFoundExitCode
+	}
+	return enterFailedExitCode
+}
@@ -13,6 +13,10 @@ import (
 )
 
 func Run() {
+	if len(os.Args) > 1 && os.Args[1] == "enter" {
+		os.Exit(runEnter(os.Args[2:]))
+	}
+
 	ctx := log.InitialContext(context.Background())
 	grpclog.SetLoggerV2(zapgrpc.NewLogger(log.WithContext(ctx)))
 	klog.SetLogger(zapr.NewLogger(log.WithContext(ctx)))
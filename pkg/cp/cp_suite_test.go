@@ -0,0 +1,13 @@
+package cp_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestCp(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Cp Suite")
+}
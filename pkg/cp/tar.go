@@ -0,0 +1,161 @@
+package cp
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Archive writes a tar archive of src (a file or a directory) to w. The entries in the archive are
+// placed under name instead of the base name of src, so the receiving end can extract them
+// as-is to rename the copy. The owners of the files are not kept.
+func Archive(w io.Writer, src, name string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err = os.Readlink(p)
+			if err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return fmt.Errorf("failed to create header for %s: %w", p, err)
+		}
+		hdr.Name = path.Join(name, filepath.ToSlash(rel))
+		// the local users mean nothing in the pod, so the copy is owned by whoever extracts it.
+		hdr.Uid, hdr.Gid = 0, 0
+		hdr.Uname, hdr.Gname = "", ""
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// Extract reads a tar archive from r, and writes the entries under prefix to dest. That is, prefix
+// is renamed to dest. File modes and modification times are preserved. Entries that would be
+// written outside of dest are skipped with a warning written to errOut.
+func Extract(r io.Reader, prefix, dest string, errOut io.Writer) error {
+	tr := tar.NewReader(r)
+	found := false
+	// set directory modes last, so read-only directories don't prevent writing their content.
+	dirModes := map[string]os.FileMode{}
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		name := path.Clean(hdr.Name)
+		if name != prefix && !strings.HasPrefix(name, prefix+"/") {
+			fmt.Fprintf(errOut, "warning: skipping unexpected entry %s\n", hdr.Name)
+			continue
+		}
+		found = true
+		target := filepath.Join(dest, filepath.FromSlash(strings.TrimPrefix(name, prefix)))
+		mode := hdr.FileInfo().Mode()
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			dirModes[target] = mode.Perm()
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := writeFile(target, tr, mode.Perm()); err != nil {
+				return err
+			}
+			if err := os.Chtimes(target, hdr.ModTime, hdr.ModTime); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			// don't create links that point outside of dest, as following entries could be written
+			// through them.
+			linkTarget := hdr.Linkname
+			if !filepath.IsAbs(linkTarget) {
+				linkTarget = filepath.Join(filepath.Dir(target), linkTarget)
+			}
+			if !isWithin(dest, linkTarget) {
+				fmt.Fprintf(errOut, "warning: skipping symlink %s pointing outside of the destination (%s)\n", hdr.Name, hdr.Linkname)
+				continue
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			os.Remove(target)
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		default:
+			fmt.Fprintf(errOut, "warning: skipping %s, unsupported file type\n", hdr.Name)
+		}
+	}
+	if !found {
+		return fmt.Errorf("%s not found in archive", prefix)
+	}
+	for dir, perm := range dirModes {
+		if err := os.Chmod(dir, perm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeFile(target string, r io.Reader, perm os.FileMode) error {
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, r); err != nil {
+		return err
+	}
+	// OpenFile applies the umask, and doesn't change the mode of existing files.
+	return f.Chmod(perm)
+}
+
+func isWithin(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
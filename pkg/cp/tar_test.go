@@ -0,0 +1,176 @@
+package cp_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/solo-io/kdiag/pkg/cp"
+)
+
+// archive returns a tar archive of the entries. Regular files have their name as content.
+func archive(entries ...*tar.Header) *bytes.Buffer {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range entries {
+		if hdr.Mode == 0 {
+			hdr.Mode = 0644
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(hdr.Name))
+		}
+		Expect(tw.WriteHeader(hdr)).To(Succeed())
+		if hdr.Typeflag == tar.TypeReg {
+			_, err := tw.Write([]byte(hdr.Name))
+			Expect(err).NotTo(HaveOccurred())
+		}
+	}
+	Expect(tw.Close()).To(Succeed())
+	return &buf
+}
+
+func file(name string) *tar.Header {
+	return &tar.Header{Typeflag: tar.TypeReg, Name: name}
+}
+
+var _ = Describe("Extract", func() {
+	var dest string
+	var errOut *bytes.Buffer
+
+	BeforeEach(func() {
+		dest = filepath.Join(GinkgoT().TempDir(), "dest")
+		errOut = &bytes.Buffer{}
+	})
+
+	DescribeTable("should skip entries outside of the prefix",
+		func(entry *tar.Header, warning string) {
+			Expect(cp.Extract(archive(file("src/ok"), entry), "src", dest, errOut)).To(Succeed())
+			Expect(filepath.Join(dest, "ok")).To(BeARegularFile())
+			Expect(errOut.String()).To(ContainSubstring(warning))
+			Expect(filepath.Join(filepath.Dir(dest), "escaped")).NotTo(BeAnExistingFile())
+		},
+		Entry("parent directory", file("src/../../escaped"), "skipping unexpected entry src/../../escaped"),
+		Entry("parent directory of the prefix", file("src/../escaped"), "skipping unexpected entry src/../escaped"),
+		Entry("absolute path", file("/escaped"), "skipping unexpected entry /escaped"),
+		Entry("other prefix", file("srcs/escaped"), "skipping unexpected entry srcs/escaped"),
+	)
+
+	DescribeTable("should handle symlinks",
+		func(linkname string, created bool) {
+			link := &tar.Header{Typeflag: tar.TypeSymlink, Name: "src/link", Linkname: linkname}
+			Expect(cp.Extract(archive(file("src/ok"), link), "src", dest, errOut)).To(Succeed())
+			_, err := os.Lstat(filepath.Join(dest, "link"))
+			if created {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(os.Readlink(filepath.Join(dest, "link"))).To(Equal(linkname))
+				Expect(errOut.String()).To(BeEmpty())
+			} else {
+				Expect(os.IsNotExist(err)).To(BeTrue())
+				Expect(errOut.String()).To(ContainSubstring("skipping symlink src/link pointing outside of the destination"))
+			}
+		},
+		Entry("relative within dest", "ok", true),
+		Entry("relative escaping dest", "../escaped", false),
+		Entry("absolute", "/etc/passwd", false),
+	)
+
+	It("should preserve file modes and modification times", func() {
+		modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+		exe := file("src/exe")
+		exe.Mode = 0750
+		exe.ModTime = modTime
+		dir := &tar.Header{Typeflag: tar.TypeDir, Name: "src/ro/", Mode: 0555}
+		Expect(cp.Extract(archive(exe, dir, file("src/ro/f")), "src", dest, errOut)).To(Succeed())
+		DeferCleanup(os.Chmod, filepath.Join(dest, "ro"), os.FileMode(0755))
+
+		info, err := os.Stat(filepath.Join(dest, "exe"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0750)))
+		Expect(info.ModTime().Equal(modTime)).To(BeTrue())
+		info, err = os.Stat(filepath.Join(dest, "ro"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0555)))
+		Expect(filepath.Join(dest, "ro", "f")).To(BeARegularFile())
+	})
+
+	It("should skip hard links with a warning", func() {
+		link := &tar.Header{Typeflag: tar.TypeLink, Name: "src/hard", Linkname: "src/ok"}
+		Expect(cp.Extract(archive(file("src/ok"), link), "src", dest, errOut)).To(Succeed())
+		Expect(filepath.Join(dest, "hard")).NotTo(BeAnExistingFile())
+		Expect(errOut.String()).To(ContainSubstring("warning: skipping src/hard, unsupported file type"))
+	})
+
+	It("should fail when the prefix is not in the archive", func() {
+		err := cp.Extract(archive(file("other/f")), "src", dest, errOut)
+		Expect(err).To(MatchError("src not found in archive"))
+	})
+})
+
+var _ = Describe("Archive", func() {
+	It("should round trip a nested directory", func() {
+		src := filepath.Join(GinkgoT().TempDir(), "src")
+		Expect(os.MkdirAll(filepath.Join(src, "a", "b"), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(src, "top"), []byte("top"), 0644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(src, "a", "b", "deep"), []byte("deep"), 0700)).To(Succeed())
+		Expect(os.Symlink("b/deep", filepath.Join(src, "a", "link"))).To(Succeed())
+
+		var buf bytes.Buffer
+		Expect(cp.Archive(&buf, src, "renamed")).To(Succeed())
+		dest := filepath.Join(GinkgoT().TempDir(), "dest")
+		var errOut bytes.Buffer
+		Expect(cp.Extract(&buf, "renamed", dest, &errOut)).To(Succeed())
+		Expect(errOut.String()).To(BeEmpty())
+
+		Expect(os.ReadFile(filepath.Join(dest, "top"))).To(Equal([]byte("top")))
+		Expect(os.ReadFile(filepath.Join(dest, "a", "b", "deep"))).To(Equal([]byte("deep")))
+		info, err := os.Stat(filepath.Join(dest, "a", "b", "deep"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0700)))
+		Expect(os.Readlink(filepath.Join(dest, "a", "link"))).To(Equal("b/deep"))
+	})
+
+	It("should archive a single file under the given name", func() {
+		src := filepath.Join(GinkgoT().TempDir(), "file")
+		Expect(os.WriteFile(src, []byte("content"), 0600)).To(Succeed())
+
+		var buf bytes.Buffer
+		Expect(cp.Archive(&buf, src, "copy")).To(Succeed())
+		dest := filepath.Join(GinkgoT().TempDir(), "copy")
+		Expect(cp.Extract(&buf, "copy", dest, GinkgoWriter)).To(Succeed())
+		Expect(os.ReadFile(dest)).To(Equal([]byte("content")))
+	})
+
+	It("should not keep the owners of the files", func() {
+		src := filepath.Join(GinkgoT().TempDir(), "src")
+		Expect(os.MkdirAll(src, 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(src, "f"), []byte("f"), 0644)).To(Succeed())
+		// the files are already owned by a user other than root when not running as root.
+		if os.Geteuid() == 0 {
+			Expect(os.Chown(filepath.Join(src, "f"), 1234, 5678)).To(Succeed())
+		}
+
+		var buf bytes.Buffer
+		Expect(cp.Archive(&buf, src, "src")).To(Succeed())
+		tr := tar.NewReader(&buf)
+		entries := 0
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			Expect(err).NotTo(HaveOccurred())
+			entries++
+			Expect(hdr.Uid).To(BeZero(), hdr.Name)
+			Expect(hdr.Gid).To(BeZero(), hdr.Name)
+			Expect(hdr.Uname).To(BeEmpty(), hdr.Name)
+			Expect(hdr.Gname).To(BeEmpty(), hdr.Name)
+		}
+		Expect(entries).To(Equal(2))
+	})
+})
@@ -0,0 +1,227 @@
+package enter
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Namespace is a linux namespace type, named like its file in /proc/<pid>/ns.
+type Namespace string
+
+const (
+	Mount  Namespace = "mnt"
+	Net    Namespace = "net"
+	PID    Namespace = "pid"
+	IPC    Namespace = "ipc"
+	UTS    Namespace = "uts"
+	Cgroup Namespace = "cgroup"
+)
+
+// AllNamespaces are the namespaces entered by default.
+var AllNamespaces = []Namespace{Mount, Net, PID, IPC, UTS, Cgroup}
+
+// ParseNamespaces parses a comma separated list of namespaces, e.g. "net,pid".
+func ParseNamespaces(s string) ([]Namespace, error) {
+	var namespaces []Namespace
+	seen := map[Namespace]bool{}
+	for _, part := range strings.Split(s, ",") {
+		ns := Namespace(strings.TrimSpace(part))
+		if ns == "" {
+			continue
+		}
+		if !isKnown(ns) {
+			return nil, fmt.Errorf("unknown namespace %q. valid namespaces are %s", ns, JoinNamespaces(AllNamespaces))
+		}
+		if !seen[ns] {
+			seen[ns] = true
+			namespaces = append(namespaces, ns)
+		}
+	}
+	if len(namespaces) == 0 {
+		return nil, fmt.Errorf("no namespaces specified")
+	}
+	return namespaces, nil
+}
+
+func isKnown(ns Namespace) bool {
+	for _, known := range AllNamespaces {
+		if ns == known {
+			return true
+		}
+	}
+	return false
+}
+
+// JoinNamespaces formats namespaces as a comma separated list, as accepted by ParseNamespaces.
+func JoinNamespaces(namespaces []Namespace) string {
+	names := make([]string, len(namespaces))
+	for i, ns := range namespaces {
+		names[i] = string(ns)
+	}
+	return strings.Join(names, ",")
+}
+
+// Options describe the process to enter and the binary to run in it.
+type Options struct {
+	// Pid of the process whose namespaces to enter.
+	Pid int
+	// Namespaces to enter. When Mount is included, the root directory of the process is used as well.
+	Namespaces []Namespace
+	// Bin is the binary to run. It is looked up in PATH before entering the namespaces, so it runs
+	// even if it doesn't exist in the target's file system.
+	Bin string
+	// Args are the arguments passed to the binary, including argv[0].
+	Args []string
+	// AsTarget runs the binary with the environment, working directory, user and groups of the
+	// target process, instead of as root in "/".
+	AsTarget bool
+	// KeepCaps also applies the capability sets of the target process. Only used with AsTarget.
+	KeepCaps bool
+}
+
+// Target is how the target process runs, as read from /proc/<pid>.
+type Target struct {
+	Env []string
+	// Dir is the working directory, as seen in the mount namespace of the process.
+	Dir    string
+	Uid    int
+	Gid    int
+	Groups []int
+	Caps   Capabilities
+}
+
+// Capabilities are capability sets, as bit masks.
+type Capabilities struct {
+	Inheritable uint64
+	Permitted   uint64
+	Effective   uint64
+	Bounding    uint64
+}
+
+// ReadTarget reads the environment, working directory and credentials of a process.
+func ReadTarget(pid int) (*Target, error) {
+	environ, err := os.ReadFile(fmt.Sprintf("/proc/%d/environ", pid))
+	if err != nil {
+		return nil, &Error{Op: "read environment", Pid: pid, Err: err}
+	}
+	dir, err := os.Readlink(fmt.Sprintf("/proc/%d/cwd", pid))
+	if err != nil {
+		return nil, &Error{Op: "read cwd", Pid: pid, Err: err}
+	}
+	status, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return nil, &Error{Op: "read status", Pid: pid, Err: err}
+	}
+	defer status.Close()
+
+	t := &Target{
+		Env: parseEnviron(environ),
+		Dir: dir,
+	}
+	if err := parseStatus(status, t); err != nil {
+		return nil, &Error{Op: "read status", Pid: pid, Err: err}
+	}
+	return t, nil
+}
+
+func parseEnviron(data []byte) []string {
+	env := []string{}
+	for _, kv := range bytes.Split(data, []byte{0}) {
+		if len(kv) != 0 {
+			env = append(env, string(kv))
+		}
+	}
+	return env
+}
+
+// parseStatus fills the credentials of t from the content of /proc/<pid>/status.
+func parseStatus(r io.Reader, t *Target) error {
+	found := map[string]bool{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(value)
+		var err error
+		switch key {
+		case "Uid":
+			// real, effective, saved and file system ids. use the effective one.
+			t.Uid, err = parseID(fields)
+		case "Gid":
+			t.Gid, err = parseID(fields)
+		case "Groups":
+			t.Groups = make([]int, len(fields))
+			for i, f := range fields {
+				if t.Groups[i], err = strconv.Atoi(f); err != nil {
+					break
+				}
+			}
+		case "CapInh":
+			t.Caps.Inheritable, err = parseCaps(fields)
+		case "CapPrm":
+			t.Caps.Permitted, err = parseCaps(fields)
+		case "CapEff":
+			t.Caps.Effective, err = parseCaps(fields)
+		case "CapBnd":
+			t.Caps.Bounding, err = parseCaps(fields)
+		default:
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", key, err)
+		}
+		found[key] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	for _, key := range []string{"Uid", "Gid", "Groups", "CapInh", "CapPrm", "CapEff", "CapBnd"} {
+		if !found[key] {
+			return fmt.Errorf("missing %s", key)
+		}
+	}
+	return nil
+}
+
+func parseID(fields []string) (int, error) {
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("expected at least 2 fields, got %d", len(fields))
+	}
+	return strconv.Atoi(fields[1])
+}
+
+func parseCaps(fields []string) (uint64, error) {
+	if len(fields) != 1 {
+		return 0, fmt.Errorf("expected 1 field, got %d", len(fields))
+	}
+	return strconv.ParseUint(fields[0], 16, 64)
+}
+
+// Error describes the step that failed while entering the namespaces of a process.
+type Error struct {
+	// Op is the operation that failed, e.g. "setns".
+	Op string
+	// Pid is the pid whose namespaces we tried to enter.
+	Pid int
+	// Namespace is set when the error relates to a specific namespace.
+	Namespace Namespace
+	Err       error
+}
+
+func (e *Error) Error() string {
+	if e.Namespace != "" {
+		return fmt.Sprintf("%s %s namespace of pid %d: %v", e.Op, e.Namespace, e.Pid, e.Err)
+	}
+	return fmt.Sprintf("%s (pid %d): %v", e.Op, e.Pid, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
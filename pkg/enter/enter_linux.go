@@ -0,0 +1,257 @@
+//go:build linux
+
+package enter
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+var cloneFlags = map[Namespace]int{
+	Mount:  unix.CLONE_NEWNS,
+	Net:    unix.CLONE_NEWNET,
+	PID:    unix.CLONE_NEWPID,
+	IPC:    unix.CLONE_NEWIPC,
+	UTS:    unix.CLONE_NEWUTS,
+	Cgroup: unix.CLONE_NEWCGROUP,
+}
+
+// Exec enters the namespaces of the target process and replaces the current process with the
+// binary. Like nsenter, but it uses execveat so the binary doesn't need to exist in the target's
+// file system. This allows running a static binary in the mount namespace of a scratch container.
+// Like setns, entering a pid namespace only applies to the children of the binary, as we don't fork.
+// It only returns if something failed.
+func Exec(o Options) error {
+	bin, err := exec.LookPath(o.Bin)
+	if err != nil {
+		return &Error{Op: "find binary", Pid: o.Pid, Err: err}
+	}
+	// open the binary as an fd, so we can use it after we chroot.
+	binFd, err := unix.Open(bin, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return &Error{Op: "open binary", Pid: o.Pid, Err: fmt.Errorf("%s: %w", bin, err)}
+	}
+	defer unix.Close(binFd)
+
+	enterMount := false
+	for _, ns := range o.Namespaces {
+		if ns == Mount {
+			enterMount = true
+		}
+	}
+
+	var target *Target
+	if o.AsTarget {
+		// read it while the target's /proc is still visible.
+		target, err = ReadTarget(o.Pid)
+		if err != nil {
+			return err
+		}
+	}
+
+	// namespaces are per thread, so make sure we stay on this thread until we exec.
+	// we never unlock it, so the thread is discarded if we fail.
+	runtime.LockOSThread()
+
+	rootFd := -1
+	if enterMount {
+		// a thread can't change its mount namespace while it shares its file system attributes with
+		// other threads, as go threads do.
+		if err := unix.Unshare(unix.CLONE_FS); err != nil {
+			return &Error{Op: "unshare", Pid: o.Pid, Namespace: Mount, Err: err}
+		}
+		// get the root path, so we can chroot to it. open it before we move to the target's mount
+		// namespace, where our /proc is not visible.
+		rootFd, err = unix.Open(fmt.Sprintf("/proc/%d/root", o.Pid), unix.O_RDONLY|unix.O_CLOEXEC, 0)
+		if err != nil {
+			return &Error{Op: "open root", Pid: o.Pid, Err: err}
+		}
+		defer unix.Close(rootFd)
+	}
+
+	if err := setns(o.Pid, o.Namespaces); err != nil {
+		return err
+	}
+
+	if enterMount {
+		if err := unix.Fchdir(rootFd); err != nil {
+			return &Error{Op: "fchdir", Pid: o.Pid, Err: err}
+		}
+		if err := unix.Chroot("."); err != nil {
+			return &Error{Op: "chroot", Pid: o.Pid, Err: err}
+		}
+		if err := unix.Chdir("/"); err != nil {
+			return &Error{Op: "chdir", Pid: o.Pid, Err: err}
+		}
+	}
+
+	// the environment of the debug image only makes sense in its own file system.
+	env := []string{}
+	if !enterMount {
+		env = os.Environ()
+	}
+	if target != nil {
+		env = target.Env
+		if err := setCredentials(o.Pid, target, o.KeepCaps); err != nil {
+			return err
+		}
+		// the working directory is only meaningful in the target's file system. change it after
+		// changing the credentials, so it fails like it would for the target.
+		if enterMount {
+			if err := unix.Chdir(target.Dir); err != nil {
+				return &Error{Op: "chdir", Pid: o.Pid, Err: fmt.Errorf("%s: %w", target.Dir, err)}
+			}
+		}
+	}
+	return execveat(o.Pid, binFd, o.Args, env)
+}
+
+// setCredentials changes the credentials of the current thread to these of the target. We exec from
+// this thread, so the other threads don't matter. When keepCaps is false, the binary runs with
+// the capabilities a program started by the target would get on exec: none for non-root users, and
+// the target's bounding set for root.
+func setCredentials(pid int, target *Target, keepCaps bool) error {
+	// drop from the bounding set while we still have CAP_SETPCAP. this limits the capabilities
+	// the binary gets on exec when the target runs as root, like it does for the target's processes.
+	for c := 0; c < 64; c++ {
+		if target.Caps.Bounding&(1<<c) != 0 {
+			continue
+		}
+		if err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(c), 0, 0, 0); err != nil {
+			// we are past the last capability the kernel knows of.
+			if errors.Is(err, unix.EINVAL) {
+				break
+			}
+			return &Error{Op: "drop bounding capabilities", Pid: pid, Err: err}
+		}
+	}
+	if keepCaps {
+		// keep our permitted capabilities when changing to a non-root user, so we can set the
+		// target's capabilities after.
+		if err := unix.Prctl(unix.PR_SET_KEEPCAPS, 1, 0, 0, 0); err != nil {
+			return &Error{Op: "keep capabilities", Pid: pid, Err: err}
+		}
+	}
+
+	if err := unix.Setgroups(target.Groups); err != nil {
+		return &Error{Op: "setgroups", Pid: pid, Err: err}
+	}
+	if err := unix.Setresgid(target.Gid, target.Gid, target.Gid); err != nil {
+		return &Error{Op: "setgid", Pid: pid, Err: err}
+	}
+	if err := unix.Setresuid(target.Uid, target.Uid, target.Uid); err != nil {
+		return &Error{Op: "setuid", Pid: pid, Err: err}
+	}
+
+	if keepCaps {
+		return setCaps(pid, target.Caps)
+	}
+	return nil
+}
+
+// setCaps sets the capability sets of the current thread. Exec clears the capabilities of non-root
+// users, unless they are in the ambient set, so the effective capabilities are made ambient too.
+func setCaps(pid int, caps Capabilities) error {
+	inheritable := caps.Inheritable | caps.Effective
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	data := [2]unix.CapUserData{
+		{
+			Effective:   uint32(caps.Effective),
+			Permitted:   uint32(caps.Permitted),
+			Inheritable: uint32(inheritable),
+		},
+		{
+			Effective:   uint32(caps.Effective >> 32),
+			Permitted:   uint32(caps.Permitted >> 32),
+			Inheritable: uint32(inheritable >> 32),
+		},
+	}
+	if err := unix.Capset(&hdr, &data[0]); err != nil {
+		return &Error{Op: "capset", Pid: pid, Err: err}
+	}
+
+	for c := 0; c < 64; c++ {
+		if caps.Effective&(1<<c) == 0 {
+			continue
+		}
+		if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_RAISE, uintptr(c), 0, 0); err != nil {
+			return &Error{Op: "raise ambient capabilities", Pid: pid, Err: err}
+		}
+	}
+	return nil
+}
+
+// setns moves the current thread to the namespaces of pid. It uses a pidfd to enter all of them at
+// once when the kernel supports it (5.8+), and falls back to the files in /proc/<pid>/ns otherwise.
+func setns(pid int, namespaces []Namespace) error {
+	flags := 0
+	for _, ns := range namespaces {
+		flags |= cloneFlags[ns]
+	}
+
+	pidFd, err := unix.PidfdOpen(pid, 0)
+	if err == nil {
+		err = unix.Setns(pidFd, flags)
+		unix.Close(pidFd)
+		if err == nil {
+			return nil
+		}
+		// kernels 5.3-5.7 have pidfd_open, but can't setns with it.
+		if !errors.Is(err, unix.EINVAL) {
+			return &Error{Op: "setns", Pid: pid, Err: err}
+		}
+	} else if !errors.Is(err, unix.ENOSYS) {
+		return &Error{Op: "pidfd_open", Pid: pid, Err: err}
+	}
+
+	// open all the namespace files first, as /proc may not be visible once we change namespaces.
+	fds := make([]int, len(namespaces))
+	for i, ns := range namespaces {
+		fd, err := unix.Open(fmt.Sprintf("/proc/%d/ns/%s", pid, ns), unix.O_RDONLY|unix.O_CLOEXEC, 0)
+		if err != nil {
+			for _, fd := range fds[:i] {
+				unix.Close(fd)
+			}
+			return &Error{Op: "open", Pid: pid, Namespace: ns, Err: err}
+		}
+		fds[i] = fd
+	}
+	defer func() {
+		for _, fd := range fds {
+			unix.Close(fd)
+		}
+	}()
+	for i, ns := range namespaces {
+		if err := unix.Setns(fds[i], cloneFlags[ns]); err != nil {
+			return &Error{Op: "setns", Pid: pid, Namespace: ns, Err: err}
+		}
+	}
+	return nil
+}
+
+func execveat(pid, fd int, args, env []string) error {
+	argv, err := syscall.SlicePtrFromStrings(args)
+	if err != nil {
+		return &Error{Op: "execveat", Pid: pid, Err: err}
+	}
+	envv, err := syscall.SlicePtrFromStrings(env)
+	if err != nil {
+		return &Error{Op: "execveat", Pid: pid, Err: err}
+	}
+	empty, err := syscall.BytePtrFromString("")
+	if err != nil {
+		return &Error{Op: "execveat", Pid: pid, Err: err}
+	}
+	// this still works even though the binary doesn't exist in our file system, because we already
+	// have an fd open to it.
+	_, _, errno := unix.Syscall6(unix.SYS_EXECVEAT, uintptr(fd), uintptr(unsafe.Pointer(empty)),
+		uintptr(unsafe.Pointer(&argv[0])), uintptr(unsafe.Pointer(&envv[0])), unix.AT_EMPTY_PATH, 0)
+	return &Error{Op: "execveat", Pid: pid, Err: os.NewSyscallError("execveat", errno)}
+}
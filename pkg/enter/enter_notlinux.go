@@ -0,0 +1,9 @@
+//go:build !linux
+
+package enter
+
+import "errors"
+
+func Exec(o Options) error {
+	return &Error{Op: "enter", Pid: o.Pid, Err: errors.New("not implemented")}
+}
@@ -0,0 +1,13 @@
+package enter_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestEnter(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Enter Suite")
+}
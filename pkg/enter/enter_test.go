@@ -0,0 +1,81 @@
+package enter_test
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/solo-io/kdiag/pkg/enter"
+)
+
+var _ = Describe("Enter", func() {
+	Context("ParseNamespaces", func() {
+		It("should parse a list of namespaces", func() {
+			namespaces, err := enter.ParseNamespaces("net, pid,net")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(namespaces).To(Equal([]enter.Namespace{enter.Net, enter.PID}))
+		})
+
+		It("should round trip all namespaces", func() {
+			namespaces, err := enter.ParseNamespaces(enter.JoinNamespaces(enter.AllNamespaces))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(namespaces).To(Equal(enter.AllNamespaces))
+		})
+
+		It("should reject unknown namespaces", func() {
+			_, err := enter.ParseNamespaces("net,user")
+			Expect(err).To(MatchError(ContainSubstring(`unknown namespace "user"`)))
+		})
+
+		It("should reject an empty list", func() {
+			_, err := enter.ParseNamespaces(" , ")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Error", func() {
+		It("should describe the failed step", func() {
+			err := &enter.Error{Op: "setns", Pid: 42, Namespace: enter.Net, Err: errors.New("operation not permitted")}
+			Expect(err.Error()).To(Equal("setns net namespace of pid 42: operation not permitted"))
+
+			err = &enter.Error{Op: "chroot", Pid: 42, Err: errors.New("operation not permitted")}
+			Expect(err.Error()).To(Equal("chroot (pid 42): operation not permitted"))
+		})
+	})
+
+	Context("ReadTarget", func() {
+		It("should read the environment, cwd and credentials of a process", func() {
+			target, err := enter.ReadTarget(os.Getpid())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(target.Env).To(ConsistOf(os.Environ()))
+			wd, err := os.Getwd()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(target.Dir).To(Equal(wd))
+			Expect(target.Uid).To(Equal(os.Geteuid()))
+			Expect(target.Gid).To(Equal(os.Getegid()))
+			groups, err := os.Getgroups()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(target.Groups).To(ConsistOf(groups))
+			Expect(target.Caps.Bounding).NotTo(BeZero())
+		})
+
+		It("should fail for a missing process", func() {
+			_, err := enter.ReadTarget(-1)
+			var enterErr *enter.Error
+			Expect(errors.As(err, &enterErr)).To(BeTrue())
+		})
+	})
+
+	Context("Exec", func() {
+		It("should fail before entering when the binary is missing", func() {
+			err := enter.Exec(enter.Options{Pid: 1, Namespaces: enter.AllNamespaces, Bin: "no-such-binary-kdiag", Args: []string{"no-such-binary-kdiag"}})
+			var enterErr *enter.Error
+			Expect(errors.As(err, &enterErr)).To(BeTrue())
+			Expect(enterErr.Op).To(Equal("find binary"))
+			Expect(errors.Is(err, exec.ErrNotFound)).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,69 @@
+package logs_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"regexp"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/solo-io/kdiag/pkg/logs"
+)
+
+var _ = Describe("Command", func() {
+	var (
+		pods     = fake.NewSimpleClientset(runningPod("pod1", "id1")).CoreV1()
+		podNames = []logs.PodAndContainerName{{PodName: "pod1"}}
+		out      *syncBuffer
+	)
+	BeforeEach(func() {
+		out = &syncBuffer{}
+	})
+
+	run := func(printer logs.MultiLogPrinter) error {
+		printer.Out, printer.ErrOut, printer.Namespace = out, out, "default"
+		return printer.PrintLogs(context.Background(), pods, podNames)
+	}
+
+	It("should succeed when the command succeeds", func() {
+		Expect(run(logs.MultiLogPrinter{Args: []string{"true"}})).To(Succeed())
+	})
+
+	It("should return the exit code of the command", func() {
+		err := run(logs.MultiLogPrinter{Args: []string{"sh", "-c", "exit 3"}})
+		var cmdErr *logs.CommandError
+		Expect(errors.As(err, &cmdErr)).To(BeTrue())
+		Expect(cmdErr.ExitCode()).To(Equal(3))
+	})
+
+	It("should return the signal of a killed command like a shell does", func() {
+		err := run(logs.MultiLogPrinter{Args: []string{"sh", "-c", "kill -TERM $$"}})
+		var cmdErr *logs.CommandError
+		Expect(errors.As(err, &cmdErr)).To(BeTrue())
+		Expect(cmdErr.ExitCode()).To(Equal(128 + 15))
+	})
+
+	It("should prefer the failure of the command to fail-on matches", func() {
+		err := run(logs.MultiLogPrinter{Args: []string{"false"}, LogDrainTime: 100 * time.Millisecond, FailOn: []*regexp.Regexp{regexp.MustCompile("fake")}})
+		var cmdErr *logs.CommandError
+		Expect(errors.As(err, &cmdErr)).To(BeTrue())
+		Expect(out.buf.String()).To(ContainSubstring("1 log lines matched a failure pattern"))
+	})
+})
+
+// syncBuffer is written by both the command and the print loop.
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
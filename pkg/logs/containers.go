@@ -0,0 +1,71 @@
+package logs
+
+import (
+	"path"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// IsContainerGlob returns true if the container name is a glob pattern, e.g. "istio-*".
+func IsContainerGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// SelectContainers returns the names of the containers of the pod to follow. The pattern is a
+// container name, or a glob that is matched against all the containers of the pod, including init
+// and ephemeral containers. An empty pattern selects the default container, returned as an empty
+// name, or all the containers if all is set.
+func SelectContainers(pod *corev1.Pod, pattern string, all bool) []string {
+	if pattern == "" && !all {
+		return []string{""}
+	}
+	if pattern != "" && !IsContainerGlob(pattern) {
+		return []string{pattern}
+	}
+	var names []string
+	for _, name := range containerNames(pod) {
+		if pattern != "" {
+			// the pattern was validated with ValidateContainerGlob.
+			if ok, _ := path.Match(pattern, name); !ok {
+				continue
+			}
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// ValidateContainerGlob returns an error if the pattern is not a valid glob.
+func ValidateContainerGlob(pattern string) error {
+	_, err := path.Match(pattern, "")
+	return err
+}
+
+// containerNames returns the names of all the containers of the pod: init containers first, as they
+// run first, then the containers and the ephemeral containers.
+func containerNames(pod *corev1.Pod) []string {
+	var names []string
+	for _, c := range pod.Spec.InitContainers {
+		names = append(names, c.Name)
+	}
+	for _, c := range pod.Spec.Containers {
+		names = append(names, c.Name)
+	}
+	for _, c := range pod.Spec.EphemeralContainers {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+// containerStatus returns the status of the container with this name, of any kind.
+func containerStatus(pod *corev1.Pod, name string) *corev1.ContainerStatus {
+	for _, statuses := range [][]corev1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses, pod.Status.EphemeralContainerStatuses} {
+		for i := range statuses {
+			if statuses[i].Name == name {
+				return &statuses[i]
+			}
+		}
+	}
+	return nil
+}
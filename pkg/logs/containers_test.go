@@ -0,0 +1,42 @@
+package logs_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/solo-io/kdiag/pkg/logs"
+)
+
+var _ = Describe("SelectContainers", func() {
+	pod := &corev1.Pod{Spec: corev1.PodSpec{
+		InitContainers: []corev1.Container{{Name: "istio-init"}},
+		Containers:     []corev1.Container{{Name: "app"}, {Name: "istio-proxy"}},
+		EphemeralContainers: []corev1.EphemeralContainer{{
+			EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "dbg-tools-1234"},
+		}},
+	}}
+
+	It("should select the default container", func() {
+		Expect(logs.SelectContainers(pod, "", false)).To(Equal([]string{""}))
+	})
+
+	It("should select containers by name, even if the pod doesn't have them", func() {
+		Expect(logs.SelectContainers(pod, "other", false)).To(Equal([]string{"other"}))
+	})
+
+	It("should match globs against all kinds of containers", func() {
+		Expect(logs.SelectContainers(pod, "istio-*", false)).To(Equal([]string{"istio-init", "istio-proxy"}))
+		Expect(logs.SelectContainers(pod, "dbg-tools-*", false)).To(Equal([]string{"dbg-tools-1234"}))
+		Expect(logs.SelectContainers(pod, "nothing-*", false)).To(BeEmpty())
+	})
+
+	It("should select all the containers", func() {
+		Expect(logs.SelectContainers(pod, "", true)).To(Equal([]string{"istio-init", "app", "istio-proxy", "dbg-tools-1234"}))
+	})
+
+	It("should reject invalid globs", func() {
+		Expect(logs.ValidateContainerGlob("istio-[")).To(HaveOccurred())
+		Expect(logs.ValidateContainerGlob("istio-*")).To(Succeed())
+	})
+})
@@ -0,0 +1,101 @@
+package logs_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/solo-io/kdiag/pkg/logs"
+)
+
+// followedBody returns its lines, then blocks like a followed log stream until ctx is done.
+type followedBody struct {
+	ctx   context.Context
+	lines []byte
+}
+
+func (b *followedBody) Read(p []byte) (int, error) {
+	if len(b.lines) != 0 {
+		n := copy(p, b.lines)
+		b.lines = b.lines[n:]
+		return n, nil
+	}
+	<-b.ctx.Done()
+	return 0, b.ctx.Err()
+}
+
+func (b *followedBody) Close() error {
+	return nil
+}
+
+var _ = Describe("Interrupt", func() {
+	var (
+		out, errOut *bytes.Buffer
+		ctx         context.Context
+		cancel      context.CancelFunc
+	)
+	BeforeEach(func() {
+		out, errOut = &bytes.Buffer{}, &bytes.Buffer{}
+		ctx, cancel = context.WithCancel(context.Background())
+		DeferCleanup(cancel)
+	})
+	following := func(lines map[string]string) *flakyPods {
+		return &flakyPods{
+			PodInterface: fake.NewSimpleClientset().CoreV1().Pods("default"),
+			logs: func(name string, _ *corev1.PodLogOptions) (*http.Response, error) {
+				l, ok := lines[name]
+				if !ok {
+					return response(http.StatusNotFound, "not found"), nil
+				}
+				return &http.Response{StatusCode: http.StatusOK, Body: &followedBody{ctx: ctx, lines: []byte(l)}}, nil
+			},
+		}
+	}
+	// printLogs follows the logs until the lines are printed, then interrupts us like the user would.
+	printLogs := func(printer *logs.MultiLogPrinter, pods *flakyPods, podNames []logs.PodAndContainerName, printed string) error {
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- printer.PrintLogs(ctx, pods, podNames)
+		}()
+		// the output is only read once the printer is done.
+		Consistently(errCh, 100*time.Millisecond).ShouldNot(Receive())
+		cancel()
+		var err error
+		Eventually(errCh).Should(Receive(&err))
+		Expect(out.String()).To(ContainSubstring(printed))
+		return err
+	}
+
+	It("should print the trace of a run without a command when interrupted", func() {
+		printer := &logs.MultiLogPrinter{Out: out, ErrOut: errOut, Trace: &logs.RequestTrace{}}
+		pods := following(map[string]string{
+			"ingress": `2022-05-01T10:00:00.025Z [2022-05-01T10:00:00.000Z] "GET /productpage HTTP/1.1" 200 - 0 100 20 19 "-" "curl" "id-1" "productpage:9080" "10.0.0.1:9080"` + "\n",
+		})
+		Expect(printLogs(printer, pods, []logs.PodAndContainerName{{PodName: "ingress"}}, "request id-1: 1 hops")).To(Succeed())
+		Expect(out.String()).To(ContainSubstring("GET /productpage 200 duration=20ms upstream=10.0.0.1:9080"))
+	})
+
+	It("should report the failed streams when interrupted", func() {
+		printer := &logs.MultiLogPrinter{Out: out, ErrOut: errOut}
+		pods := following(map[string]string{"pod1": "2022-05-01T10:00:00Z hello\n"})
+		podNames := []logs.PodAndContainerName{{PodName: "pod1"}, {PodName: "missing"}}
+		Expect(printLogs(printer, pods, podNames, "pod1: hello\n")).To(Succeed())
+		Expect(errOut.String()).To(ContainSubstring("1 of 2 log streams failed:\n  missing: failed to stream logs:"))
+	})
+
+	It("should flush the reordered lines when interrupted", func() {
+		printer := &logs.MultiLogPrinter{Out: out, ErrOut: errOut, ReorderWindow: time.Hour}
+		pods := following(map[string]string{
+			"pod1": "2022-05-01T10:00:00.2Z second\n",
+			"pod2": "2022-05-01T10:00:00.1Z first\n",
+		})
+		podNames := []logs.PodAndContainerName{{PodName: "pod1"}, {PodName: "pod2"}}
+		Expect(printLogs(printer, pods, podNames, "pod2: first\npod1: second\n")).To(Succeed())
+	})
+})
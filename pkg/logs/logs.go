@@ -1,37 +1,57 @@
 package logs
 
 import (
-	"bufio"
+	"container/heap"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"os"
 	"os/exec"
-	"strings"
+	"regexp"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/fatih/color"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 )
 
 type logEntry struct {
 	podName string
-	err     error
-	log     string
-	done    bool
-	fprintf func(w io.Writer, format string, a ...interface{}) (n int, err error)
+	// the pod and container the line came from. the container may be empty if the default
+	// container was requested, unless the printer records the sources.
+	source PodAndContainerName
+	err    error
+	log    string
+	// when the line was logged, as reported by kubernetes.
+	timestamp time.Time
+	// when we received the line.
+	arrival time.Time
+	// the order in which the print loop received the entry.
+	seq  uint64
+	done bool
+	// set when following a container, in watch mode.
+	started bool
+	color   *color.Color
 }
 
 type PodAndContainerName struct {
-	PodName string
+	// may be empty, for the namespace of the printer. when set, it is shown in the name.
+	Namespace string
+	PodName   string
 	// may be empty
 	ContainerName string
 }
 
 func (p *PodAndContainerName) String() string {
 	podnameToPrint := p.PodName
+	if p.Namespace != "" {
+		podnameToPrint = p.Namespace + "/" + podnameToPrint
+	}
 	if p.ContainerName != "" {
 		podnameToPrint += ":" + p.ContainerName
 	}
@@ -57,97 +77,311 @@ type MultiLogPrinter struct {
 	In           io.Reader
 	Args         []string
 	LogDrainTime time.Duration
+	// Timestamps prints the time each line was logged.
+	Timestamps bool
+	// CommandEnv is added to the environment of the user command.
+	CommandEnv []string
+	// Trace, when set, collects the lines of a request instead of printing them, and prints them as
+	// a timeline when done.
+	Trace *RequestTrace
+	// Processor, when set, filters and projects the lines before they are printed.
+	Processor *LineProcessor
+	// ReorderWindow, when not zero, buffers lines for this long so lines from different streams
+	// are printed in the order they were logged, rather than in the order they arrived.
+	ReorderWindow time.Duration
+	// Output is the format of the lines printed to Out. defaults to text.
+	Output Output
+	// Namespace of the pods that don't have one.
+	Namespace string
+	// OutputDir, when set, is where the lines of each pod and container are also written to a file
+	// of their own, in the Output format.
+	OutputDir string
+	// Quiet doesn't print the lines to Out, e.g. when they are only written to OutputDir.
+	Quiet bool
+	// Since, when not zero, shows the lines logged in this duration before the logs are followed,
+	// rather than only new lines.
+	Since time.Duration
+	// SinceTime, when not zero, shows the lines logged since this time.
+	SinceTime time.Time
+	// TailLines, when set, shows this many lines from the end of the logs.
+	TailLines *int64
+	// Previous shows the logs of the previous instance of the containers, e.g. to see why they
+	// crashed. Implies Snapshot.
+	Previous bool
+	// Snapshot prints the logs available now and returns, instead of following them.
+	Snapshot bool
+	// Highlight colors the parts of the printed lines that match these patterns.
+	Highlight []*regexp.Regexp
+	// FailOn patterns make the run return a *FailOnError if any line matches them, e.g. to fail a
+	// CI check. Matches are highlighted too.
+	FailOn []*regexp.Regexp
+	// TUI shows the lines in an interactive terminal ui on In and Out, that can be paused, searched
+	// and filtered, instead of printing them. In and Out must be a terminal.
+	TUI bool
+	// MaxRequests bounds the number of requests to open streams that are in flight at once.
+	// defaults to DefaultMaxRequests.
+	MaxRequests int
+	// Retries is the number of times a failed stream is retried in a row, before it is given up.
+	Retries int
+	// RetryBackoff is the delay before the first retry. defaults to DefaultRetryBackoff.
+	RetryBackoff time.Duration
+}
+
+// podLogOptions returns the options of the stream of a container that was already running when we
+// started.
+func (m *MultiLogPrinter) podLogOptions(container string) *corev1.PodLogOptions {
+	opts := &corev1.PodLogOptions{
+		Container: container,
+		Follow:    !m.Snapshot && !m.Previous,
+		Previous:  m.Previous,
+		// timestamps are used to order the lines, and to reconnect without duplicating lines. they
+		// are only printed if asked to.
+		Timestamps: true,
+		TailLines:  m.TailLines,
+	}
+	if m.Since != 0 {
+		seconds := int64(math.Ceil(m.Since.Seconds()))
+		opts.SinceSeconds = &seconds
+	}
+	if !m.SinceTime.IsZero() {
+		sinceTime := metav1.NewTime(m.SinceTime)
+		opts.SinceTime = &sinceTime
+	}
+	// when following, only show new lines unless asked otherwise.
+	if opts.Follow && opts.TailLines == nil && opts.SinceSeconds == nil && opts.SinceTime == nil {
+		zero := int64(0)
+		opts.TailLines = &zero
+	}
+	return opts
+}
+
+// namespaceOf returns the namespace of the pod.
+func (m *MultiLogPrinter) namespaceOf(podName PodAndContainerName) string {
+	if podName.Namespace != "" {
+		return podName.Namespace
+	}
+	return m.Namespace
+}
+
+// recordsSources returns true if the container of each line needs to be known, rather than the
+// default container.
+func (m *MultiLogPrinter) recordsSources() bool {
+	return m.Output == OutputJSONL || m.OutputDir != ""
+}
+
+// statusOut is where messages that are not log lines are printed, so jsonl output only has records.
+func (m *MultiLogPrinter) statusOut() io.Writer {
+	if m.Output == OutputJSONL {
+		return m.ErrOut
+	}
+	return m.Out
+}
+
+// logPipeline reads log streams, and sends their lines to the print loop.
+type logPipeline struct {
+	ctx     context.Context
+	entries chan logEntry
+	watch   bool
+	// returns the options of the streams of containers that were already running when we started.
+	logOptions   func(container string) *corev1.PodLogOptions
+	processor    *LineProcessor
+	trace        *RequestTrace
+	failOn       []*regexp.Regexp
+	retries      int
+	retryBackoff time.Duration
+	// bounds the number of requests in flight.
+	requests chan struct{}
+
+	lock    sync.Mutex
+	stopped bool
+	wg      sync.WaitGroup
+	// colors are assigned by name, so a pod keeps its color when we re-attach to it.
+	colors map[string]*color.Color
+	// the lines that matched the failOn patterns.
+	match *FailOnError
+	// the number of streams, and the ones that failed.
+	streams  int
+	failures []streamFailure
+}
+
+func (p *logPipeline) colorFor(name string) *color.Color {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	c, ok := p.colors[name]
+	if !ok {
+		c = pallete(len(p.colors))
+		p.colors[name] = c
+	}
+	return c
+}
+
+// checkFailOn records the line if it matches the failOn patterns.
+func (p *logPipeline) checkFailOn(podName, line string) {
+	if !matchAny(p.failOn, line) {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.match == nil {
+		p.match = &FailOnError{PodName: podName, Line: line}
+	}
+	p.match.Matches++
+}
+
+// stop waits for the streams to end, after their context was canceled. no streams can be followed
+// after it is called.
+func (p *logPipeline) stop() {
+	p.lock.Lock()
+	p.stopped = true
+	p.lock.Unlock()
+	p.wg.Wait()
 }
 
 // Run lists all available namespaces on a user's KUBECONFIG or updates the
 // current context based on a provided namespace.
-func (m *MultiLogPrinter) PrintLogs(ctx context.Context, podclient typedcorev1.PodInterface, podNames []PodAndContainerName) error {
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
+func (m *MultiLogPrinter) PrintLogs(ctx context.Context, pods typedcorev1.PodsGetter, podNames []PodAndContainerName) error {
+	return m.run(ctx, false, func(p *logPipeline) error {
+		for _, podName := range podNames {
+			p.follow(&streamRequest{
+				podName:          podName.String(),
+				source:           podName,
+				resolveContainer: m.recordsSources(),
+				podclient:        pods.Pods(m.namespaceOf(podName)),
+				opts:             p.logOptions(podName.ContainerName),
+			})
+		}
+		return nil
+	})
+}
 
-	// exec!
-	zero := int64(0)
-	logEntries := make(chan logEntry)
-	allDone := make(chan struct{})
+// CommandError is returned when the user command failed.
+type CommandError struct {
+	Err error
+}
 
-	var wg sync.WaitGroup
-	for i, podName := range podNames {
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("command failed: %v", e.Err)
+}
 
-		podNameColor := pallete(i)
-		// get the logs from the pod
-		currOpts := &corev1.PodLogOptions{
-			Container: podName.ContainerName,
-			Follow:    true,
-			TailLines: &zero,
-		}
-		readCloser, err := podclient.GetLogs(podName.PodName, currOpts).Stream(ctx)
-		if err != nil {
-			return err
-		}
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
 
-		wg.Add(1)
-		go func(podName string) {
-			defer wg.Done()
-			defer readCloser.Close()
-			r := bufio.NewReader(readCloser)
-			for {
-				bytes, err := r.ReadBytes('\n')
+// ExitCode returns the exit code of the command, like a shell does: 128 plus the signal number if
+// it was killed by a signal. returns -1 if the command didn't exit, e.g. if waiting for it failed.
+func (e *CommandError) ExitCode() int {
+	var exitErr *exec.ExitError
+	if !errors.As(e.Err, &exitErr) {
+		return -1
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return exitErr.ExitCode()
+}
 
-				if len(bytes) != 0 {
-					logline := strings.TrimSuffix(string(bytes), "\n")
-					logEntries <- logEntry{podName: podName, fprintf: podNameColor.Fprintf, log: logline}
-				}
-				if err != nil {
-					if err != io.EOF {
-						err := fmt.Errorf("failed to read logs: %w", err)
-						logEntries <- logEntry{podName: podName, fprintf: podNameColor.Fprintf, err: err, done: true}
-					} else {
-						logEntries <- logEntry{podName: podName, fprintf: podNameColor.Fprintf, done: true}
-					}
-					return
-				}
+// run starts the print loop, and calls start to start following logs. It then runs the user command,
+// and returns a *CommandError if it fails, or waits until the user interrupts us. When not watching,
+// it also returns once all the streams are done.
+func (m *MultiLogPrinter) run(ctx context.Context, watch bool, start func(p *logPipeline) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-			}
-		}(podName.String())
+	p := &logPipeline{
+		ctx:          ctx,
+		entries:      make(chan logEntry),
+		watch:        watch,
+		logOptions:   m.podLogOptions,
+		processor:    m.Processor,
+		trace:        m.Trace,
+		failOn:       m.FailOn,
+		retries:      m.Retries,
+		retryBackoff: m.RetryBackoff,
+		requests:     make(chan struct{}, m.MaxRequests),
+		colors:       map[string]*color.Color{},
+	}
+	if m.MaxRequests <= 0 {
+		p.requests = make(chan struct{}, DefaultMaxRequests)
+	}
+	if p.retryBackoff <= 0 {
+		p.retryBackoff = DefaultRetryBackoff
 	}
 
-	go func() {
-		wg.Wait()
-		close(allDone)
-	}()
+	var view *tui
+	if m.TUI {
+		var err error
+		view, err = startTUI(m.In, m.Out, cancel)
+		if err != nil {
+			return err
+		}
+	}
 
 	printLoopDone := make(chan struct{})
 	go func() {
 		defer close(printLoopDone)
-		for entry := range logEntries {
-			if entry.err != nil {
-				if !errors.Is(entry.err, context.Canceled) {
-					fmt.Fprintf(m.ErrOut, "error reading logs for %s: %v\n", entry.podName, entry.err)
-				}
-			} else if entry.done {
-				fmt.Fprintf(m.Out, "pod %s is done\n", entry.podName)
-			} else {
-				entry.fprintf(m.Out, "%s:", entry.podName)
-				fmt.Fprintf(m.Out, " %s\n", entry.log)
-			}
-		}
+		m.printLoop(p.entries, view)
 	}()
+	// stop everything. used both on error and on success.
+	shutdown := func() {
+		// cancel the log context
+		cancel()
+		// drain pending logs
+		p.stop()
+		// close channel so print loop exits.
+		close(p.entries)
+		// wait for print loop to exit
+		<-printLoopDone
+	}
+
+	if err := start(p); err != nil {
+		shutdown()
+		return err
+	}
+
+	// when watching, new streams may start at any time, so we never wait for all of them.
+	// the terminal ui stays up until the user quits, so they can go through the lines.
+	var allDone chan struct{}
+	if !watch && view == nil {
+		allDone = make(chan struct{})
+		go func() {
+			p.wg.Wait()
+			close(allDone)
+		}()
+	}
 
+	// the error of the user command, if it failed.
+	var cmdErr error
 	if len(m.Args) > 0 {
 		cmd := exec.CommandContext(ctx, m.Args[0], m.Args[1:]...)
 		cmd.Stderr = m.ErrOut
-		cmd.Stdout = m.Out
+		cmd.Stdout = m.statusOut()
 		cmd.Stdin = m.In
+		if view != nil {
+			// the terminal belongs to the ui, so the output of the command is shown in it.
+			cmd.Stdout = view.writer("command", p.colorFor("command"))
+			cmd.Stderr = cmd.Stdout
+			cmd.Stdin = nil
+		}
+		if len(m.CommandEnv) != 0 {
+			cmd.Env = append(os.Environ(), m.CommandEnv...)
+		}
 		err := cmd.Start()
 		if err != nil {
+			shutdown()
 			return err
 		}
 		// wait until user command exits
-		cmd.Wait()
+		if err := cmd.Wait(); err != nil {
+			cmdErr = &CommandError{Err: err}
+		}
 		// command done, wait the drain time
 		if m.LogDrainTime != 0 {
 			time.Sleep(m.LogDrainTime)
 		}
+		if view != nil {
+			view.add("command", p.colorFor("command"), commandStatus(cmdErr), true)
+			<-ctx.Done()
+		}
 	} else {
 		// wait until user interrupts us.
 		// or all the pods exited
@@ -157,13 +391,188 @@ func (m *MultiLogPrinter) PrintLogs(ctx context.Context, podclient typedcorev1.P
 		}
 	}
 
-	// cancel the log context
-	cancel()
-	// drain pending logs
-	wg.Wait()
-	// close channel so print loop exits.
-	close(logEntries)
-	// wait for print loop to exit
-	<-printLoopDone
+	shutdown()
+	if m.Trace != nil {
+		m.Trace.Print(m.Out, m.ErrOut)
+	}
+	if len(p.failures) != 0 {
+		fmt.Fprintf(m.ErrOut, "%d of %d log streams failed:\n", len(p.failures), p.streams)
+		for _, f := range p.failures {
+			fmt.Fprintf(m.ErrOut, "  %s: %v\n", f.podName, f.err)
+		}
+	}
+	if cmdErr != nil {
+		// the command failed for its own reasons, which are more relevant than the matches.
+		if p.match != nil {
+			fmt.Fprintf(m.ErrOut, "%v\n", p.match)
+		}
+		return cmdErr
+	}
+	if p.match != nil {
+		return p.match
+	}
+	if len(p.failures) != 0 && len(p.failures) == p.streams {
+		return fmt.Errorf("all %d log streams failed", p.streams)
+	}
 	return nil
 }
+
+// printLoop prints the entries until the channel is closed. entries are shown in the view instead,
+// when there is one, and the view is closed when done.
+func (m *MultiLogPrinter) printLoop(entries <-chan logEntry, view *tui) {
+	if view != nil {
+		defer view.close()
+	}
+	var sink *fileSink
+	if m.OutputDir != "" {
+		sink = newFileSink(m.OutputDir, m.output())
+		defer func() {
+			if err := sink.Close(); err != nil {
+				fmt.Fprintf(m.ErrOut, "failed to close log files: %v\n", err)
+			}
+		}()
+	}
+
+	if m.ReorderWindow == 0 {
+		for entry := range entries {
+			m.printEntry(entry, sink, view)
+		}
+		return
+	}
+
+	buffer := &entryHeap{}
+	var seq uint64
+	tick := m.ReorderWindow / 4
+	if tick < time.Millisecond {
+		tick = time.Millisecond
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case entry, ok := <-entries:
+			if !ok {
+				for buffer.Len() != 0 {
+					m.printEntry(heap.Pop(buffer).(logEntry), sink, view)
+				}
+				return
+			}
+			// errors are not part of the log, report them right away.
+			if entry.err != nil {
+				m.printEntry(entry, sink, view)
+				continue
+			}
+			seq++
+			entry.seq = seq
+			heap.Push(buffer, entry)
+		case now := <-ticker.C:
+			// print lines that waited long enough for lines logged before them to arrive.
+			for buffer.Len() != 0 && now.Sub((*buffer)[0].arrival) >= m.ReorderWindow {
+				m.printEntry(heap.Pop(buffer).(logEntry), sink, view)
+			}
+		}
+	}
+}
+
+func (m *MultiLogPrinter) output() Output {
+	if m.Output == "" {
+		return OutputText
+	}
+	return m.Output
+}
+
+func (m *MultiLogPrinter) printEntry(entry logEntry, sink *fileSink, view *tui) {
+	if view != nil {
+		m.showEntry(entry, sink, view)
+		return
+	}
+	if entry.err == nil && m.Trace != nil {
+		// only the timeline of the request is printed.
+		return
+	}
+	if entry.err != nil {
+		if !errors.Is(entry.err, context.Canceled) {
+			fmt.Fprintf(m.ErrOut, "error reading logs for %s: %v\n", entry.podName, entry.err)
+		}
+		return
+	}
+	if entry.started {
+		fmt.Fprintf(m.statusOut(), "following pod %s\n", entry.podName)
+		return
+	}
+	if entry.done {
+		fmt.Fprintf(m.statusOut(), "pod %s is done\n", entry.podName)
+		return
+	}
+
+	if sink != nil {
+		m.writeEntry(sink, entry)
+	}
+	if m.Quiet {
+		return
+	}
+	if m.output() == OutputJSONL {
+		writeRecord(m.Out, m.record(entry))
+		return
+	}
+	line := m.text(entry)
+	if len(m.Highlight) != 0 || len(m.FailOn) != 0 {
+		line = highlight(line, append(append([]*regexp.Regexp{}, m.Highlight...), m.FailOn...))
+	}
+	printLine(m.Out, entry.color, entry.podName, line)
+}
+
+// showEntry shows the entry in the terminal ui.
+func (m *MultiLogPrinter) showEntry(entry logEntry, sink *fileSink, view *tui) {
+	switch {
+	case entry.err != nil:
+		if !errors.Is(entry.err, context.Canceled) {
+			view.add(entry.podName, entry.color, fmt.Sprintf("error reading logs: %v", entry.err), true)
+		}
+	case entry.started:
+		view.add(entry.podName, entry.color, "following pod", true)
+	case entry.done:
+		view.add(entry.podName, entry.color, "pod is done", true)
+	default:
+		if sink != nil {
+			m.writeEntry(sink, entry)
+		}
+		view.add(entry.podName, entry.color, m.text(entry), false)
+	}
+}
+
+func commandStatus(err error) string {
+	if err == nil {
+		return "command exited. press q to quit"
+	}
+	return fmt.Sprintf("%v. press q to quit", err)
+}
+
+// text returns the line as printed in text output.
+func (m *MultiLogPrinter) text(entry logEntry) string {
+	if m.Timestamps && !entry.timestamp.IsZero() {
+		return entry.timestamp.Format(time.RFC3339Nano) + " " + entry.log
+	}
+	return entry.log
+}
+
+// writeEntry writes the line to the file of its pod and container. errors are reported once per file.
+func (m *MultiLogPrinter) writeEntry(sink *fileSink, entry logEntry) {
+	f, err := sink.file(entry.source)
+	if f == nil {
+		if err != nil {
+			fmt.Fprintf(m.ErrOut, "error writing logs for %s: %v\n", entry.podName, err)
+		}
+		return
+	}
+	if m.output() == OutputJSONL {
+		err = writeRecord(f, m.record(entry))
+	} else {
+		_, err = fmt.Fprintln(f, m.text(entry))
+	}
+	if err != nil {
+		fmt.Fprintf(m.ErrOut, "error writing logs for %s: %v\n", entry.podName, err)
+		sink.files[entry.source] = nil
+		f.Close()
+	}
+}
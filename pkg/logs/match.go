@@ -0,0 +1,66 @@
+package logs
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/fatih/color"
+)
+
+// highlightColor is used for the parts of lines that match Highlight or FailOn patterns.
+var highlightColor = color.New(color.FgBlack, color.BgHiYellow)
+
+// FailOnError is returned when log lines matched a FailOn pattern.
+type FailOnError struct {
+	// Matches is the number of lines that matched.
+	Matches int
+	// PodName and Line are of the first line that matched.
+	PodName string
+	Line    string
+}
+
+func (e *FailOnError) Error() string {
+	return fmt.Sprintf("%d log lines matched a failure pattern. first match in %s: %s", e.Matches, e.PodName, e.Line)
+}
+
+func matchAny(patterns []*regexp.Regexp, line string) bool {
+	for _, re := range patterns {
+		if re.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// highlight colors the parts of the line that match any of the patterns.
+func highlight(line string, patterns []*regexp.Regexp) string {
+	var matches [][]int
+	for _, re := range patterns {
+		for _, m := range re.FindAllStringIndex(line, -1) {
+			if m[0] != m[1] {
+				matches = append(matches, m)
+			}
+		}
+	}
+	if len(matches) == 0 {
+		return line
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i][0] < matches[j][0] })
+
+	var out []byte
+	end := 0
+	for i := 0; i < len(matches); {
+		start, stop := matches[i][0], matches[i][1]
+		// merge overlapping matches, so their colors don't nest.
+		for i++; i < len(matches) && matches[i][0] <= stop; i++ {
+			if matches[i][1] > stop {
+				stop = matches[i][1]
+			}
+		}
+		out = append(out, line[end:start]...)
+		out = append(out, highlightColor.Sprint(line[start:stop])...)
+		end = stop
+	}
+	return string(append(out, line[end:]...))
+}
@@ -0,0 +1,34 @@
+package logs
+
+import (
+	"regexp"
+
+	"github.com/fatih/color"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("highlight", func() {
+	var noColor bool
+	BeforeEach(func() {
+		noColor = color.NoColor
+		color.NoColor = false
+	})
+	AfterEach(func() {
+		color.NoColor = noColor
+	})
+
+	It("should color the matches", func() {
+		line := highlight("GET /a 503 UF", []*regexp.Regexp{regexp.MustCompile("5[0-9][0-9]")})
+		Expect(line).To(Equal("GET /a " + highlightColor.Sprint("503") + " UF"))
+	})
+
+	It("should merge overlapping matches", func() {
+		line := highlight("upstream connect error", []*regexp.Regexp{regexp.MustCompile("upstream connect"), regexp.MustCompile("connect error")})
+		Expect(line).To(Equal(highlightColor.Sprint("upstream connect error")))
+	})
+
+	It("should leave lines without matches as is", func() {
+		Expect(highlight("GET /a 200", []*regexp.Regexp{regexp.MustCompile("5[0-9][0-9]")})).To(Equal("GET /a 200"))
+	})
+})
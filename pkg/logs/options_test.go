@@ -0,0 +1,34 @@
+package logs
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("podLogOptions", func() {
+	It("should follow new lines by default", func() {
+		opts := (&MultiLogPrinter{}).podLogOptions("app")
+		Expect(opts.Follow).To(BeTrue())
+		Expect(*opts.TailLines).To(BeEquivalentTo(0))
+	})
+
+	It("should show older lines when asked", func() {
+		opts := (&MultiLogPrinter{Since: 1500 * time.Millisecond}).podLogOptions("app")
+		Expect(opts.Follow).To(BeTrue())
+		Expect(opts.TailLines).To(BeNil())
+		Expect(*opts.SinceSeconds).To(BeEquivalentTo(2))
+
+		tail := int64(10)
+		opts = (&MultiLogPrinter{TailLines: &tail}).podLogOptions("app")
+		Expect(*opts.TailLines).To(BeEquivalentTo(10))
+	})
+
+	It("should print all the lines of the previous container", func() {
+		opts := (&MultiLogPrinter{Previous: true}).podLogOptions("app")
+		Expect(opts.Follow).To(BeFalse())
+		Expect(opts.Previous).To(BeTrue())
+		Expect(opts.TailLines).To(BeNil())
+	})
+})
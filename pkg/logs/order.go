@@ -0,0 +1,50 @@
+package logs
+
+import (
+	"strings"
+	"time"
+)
+
+// splitTimestamp splits the timestamp the kubelet adds to log lines when requested. Lines without a
+// valid timestamp get the fallback one, so they stay next to the lines around them.
+func splitTimestamp(line string, fallback time.Time) (time.Time, string) {
+	index := strings.IndexByte(line, ' ')
+	if index < 0 {
+		index = len(line)
+	}
+	timestamp, err := time.Parse(time.RFC3339Nano, line[:index])
+	if err != nil {
+		return fallback, line
+	}
+	if index == len(line) {
+		return timestamp, ""
+	}
+	return timestamp, line[index+1:]
+}
+
+// entryHeap orders entries by timestamp, and then by the order they were received, so entries from
+// the same stream keep their order.
+type entryHeap []logEntry
+
+func (h entryHeap) Len() int { return len(h) }
+
+func (h entryHeap) Less(i, j int) bool {
+	if !h[i].timestamp.Equal(h[j].timestamp) {
+		return h[i].timestamp.Before(h[j].timestamp)
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h entryHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *entryHeap) Push(x interface{}) {
+	*h = append(*h, x.(logEntry))
+}
+
+func (h *entryHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	entry := old[n-1]
+	*h = old[:n-1]
+	return entry
+}
@@ -0,0 +1,56 @@
+package logs
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/fatih/color"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Ordering", func() {
+	It("should split the timestamp of a line", func() {
+		timestamp, line := splitTimestamp("2022-05-01T10:00:00.123456789Z GET / 200", time.Time{})
+		Expect(timestamp).To(Equal(time.Date(2022, 5, 1, 10, 0, 0, 123456789, time.UTC)))
+		Expect(line).To(Equal("GET / 200"))
+	})
+
+	It("should use the fallback timestamp for lines without one", func() {
+		fallback := time.Now()
+		timestamp, line := splitTimestamp("no timestamp here", fallback)
+		Expect(timestamp).To(Equal(fallback))
+		Expect(line).To(Equal("no timestamp here"))
+	})
+
+	It("should print lines in the order they were logged", func() {
+		out := &bytes.Buffer{}
+		m := &MultiLogPrinter{Out: out, ErrOut: out, ReorderWindow: 100 * time.Millisecond, Timestamps: true}
+		entries := make(chan logEntry)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			m.printLoop(entries, nil)
+		}()
+
+		base := time.Date(2022, 5, 1, 10, 0, 0, 0, time.UTC)
+		c := color.New()
+		send := func(pod string, offset time.Duration, log string) {
+			entries <- logEntry{podName: pod, color: c, log: log, timestamp: base.Add(offset), arrival: time.Now()}
+		}
+		send("a", 2*time.Millisecond, "second")
+		send("b", 3*time.Millisecond, "third")
+		send("b", 3*time.Millisecond, "fourth")
+		send("a", time.Millisecond, "first")
+		entries <- logEntry{podName: "a", done: true, timestamp: base.Add(2 * time.Millisecond), arrival: time.Now()}
+		close(entries)
+		<-done
+
+		Expect(out.String()).To(Equal(`a: 2022-05-01T10:00:00.001Z first
+a: 2022-05-01T10:00:00.002Z second
+pod a is done
+b: 2022-05-01T10:00:00.003Z third
+b: 2022-05-01T10:00:00.003Z fourth
+`))
+	})
+})
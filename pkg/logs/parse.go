@@ -0,0 +1,344 @@
+package logs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Format is a structured log format.
+type Format string
+
+const (
+	FormatJSON   Format = "json"
+	FormatLogfmt Format = "logfmt"
+	// FormatEnvoy is the default text access log format of envoy, or the one istio uses.
+	FormatEnvoy Format = "envoy"
+)
+
+// Formats are the supported formats.
+var Formats = []Format{FormatJSON, FormatLogfmt, FormatEnvoy}
+
+func ParseFormat(s string) (Format, error) {
+	for _, f := range Formats {
+		if string(f) == s {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown log format %q. must be one of json, logfmt or envoy", s)
+}
+
+// Fields are the fields of a parsed log line. Nested json objects are flattened with dotted keys.
+type Fields map[string]string
+
+// Parse parses a log line. returns false if the line is not in this format.
+func (f Format) Parse(line string) (Fields, bool) {
+	switch f {
+	case FormatJSON:
+		return parseJSON(line)
+	case FormatLogfmt:
+		return parseLogfmt(line)
+	case FormatEnvoy:
+		return parseEnvoy(line)
+	}
+	return nil, false
+}
+
+func parseJSON(line string) (Fields, bool) {
+	d := json.NewDecoder(strings.NewReader(line))
+	d.UseNumber()
+	var obj map[string]interface{}
+	if err := d.Decode(&obj); err != nil {
+		return nil, false
+	}
+	fields := Fields{}
+	flatten(fields, "", obj)
+	return fields, true
+}
+
+func flatten(fields Fields, prefix string, obj map[string]interface{}) {
+	for k, v := range obj {
+		key := prefix + k
+		switch v := v.(type) {
+		case map[string]interface{}:
+			flatten(fields, key+".", v)
+		case string:
+			fields[key] = v
+		case json.Number:
+			fields[key] = v.String()
+		case bool:
+			fields[key] = strconv.FormatBool(v)
+		case nil:
+			fields[key] = ""
+		default:
+			b, _ := json.Marshal(v)
+			fields[key] = string(b)
+		}
+	}
+}
+
+// logfmtKey matches the keys of logfmt pairs.
+var logfmtKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.\-/]*$`)
+
+// parseLogfmt parses a line of key=value pairs. Lines with other words are free text that happens to
+// contain a '=', so they are not logfmt.
+func parseLogfmt(line string) (Fields, bool) {
+	fields := Fields{}
+	for i := 0; i < len(line); {
+		if line[i] == ' ' {
+			i++
+			continue
+		}
+		start := i
+		for i < len(line) && line[i] != '=' && line[i] != ' ' {
+			i++
+		}
+		key := line[start:i]
+		if i == len(line) || line[i] == ' ' || !logfmtKey.MatchString(key) {
+			return nil, false
+		}
+		// skip the '='
+		i++
+		if i < len(line) && line[i] == '"' {
+			value, n, ok := unquotePrefix(line[i:])
+			if !ok {
+				return nil, false
+			}
+			fields[key] = value
+			i += n
+		} else {
+			start := i
+			for i < len(line) && line[i] != ' ' {
+				i++
+			}
+			fields[key] = line[start:i]
+		}
+	}
+	return fields, len(fields) != 0
+}
+
+// unquotePrefix unquotes the quoted string at the start of s, and returns its length in s.
+func unquotePrefix(s string) (string, int, bool) {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			value, err := strconv.Unquote(s[:i+1])
+			if err != nil {
+				return "", 0, false
+			}
+			return value, i + 1, true
+		}
+	}
+	return "", 0, false
+}
+
+var (
+	// the fields of the default envoy access log format, after the start time and the request line.
+	envoyFields = []string{
+		"response_code", "response_flags", "bytes_received", "bytes_sent", "duration",
+		"upstream_service_time", "x_forwarded_for", "user_agent", "request_id", "authority", "upstream_host",
+	}
+	// the fields of the istio access log format, after the start time and the request line.
+	istioFields = []string{
+		"response_code", "response_flags", "response_code_details", "connection_termination_details",
+		"upstream_transport_failure_reason", "bytes_received", "bytes_sent", "duration", "upstream_service_time",
+		"x_forwarded_for", "user_agent", "request_id", "authority", "upstream_host", "upstream_cluster",
+		"upstream_local_address", "downstream_local_address", "downstream_remote_address",
+		"requested_server_name", "route_name",
+	}
+)
+
+func parseEnvoy(line string) (Fields, bool) {
+	tokens, ok := envoyTokens(line)
+	if !ok || len(tokens) < 2 || !strings.HasPrefix(line, "[") {
+		return nil, false
+	}
+	var names []string
+	switch len(tokens) - 2 {
+	case len(envoyFields):
+		names = envoyFields
+	case len(istioFields):
+		names = istioFields
+	default:
+		return nil, false
+	}
+
+	fields := Fields{"start_time": tokens[0]}
+	request := strings.SplitN(tokens[1], " ", 3)
+	if len(request) == 3 {
+		fields["method"], fields["path"], fields["protocol"] = request[0], request[1], request[2]
+	}
+	for i, name := range names {
+		// envoy prints "-" for missing values.
+		if value := tokens[i+2]; value != "-" {
+			fields[name] = value
+		}
+	}
+	return fields, true
+}
+
+// envoyTokens splits an access log line to its tokens: a [bracketed] start time, "quoted" strings
+// and plain words.
+func envoyTokens(line string) ([]string, bool) {
+	var tokens []string
+	for i := 0; i < len(line); {
+		switch line[i] {
+		case ' ':
+			i++
+		case '[':
+			end := strings.IndexByte(line[i:], ']')
+			if end < 0 {
+				return nil, false
+			}
+			tokens = append(tokens, line[i+1:i+end])
+			i += end + 1
+		case '"':
+			end := strings.IndexByte(line[i+1:], '"')
+			if end < 0 {
+				return nil, false
+			}
+			tokens = append(tokens, line[i+1:i+1+end])
+			i += end + 2
+		default:
+			end := strings.IndexByte(line[i:], ' ')
+			if end < 0 {
+				end = len(line) - i
+			}
+			tokens = append(tokens, line[i:i+end])
+			i += end
+		}
+	}
+	return tokens, true
+}
+
+// Condition filters parsed lines by a field, e.g. "response_code>=500".
+type Condition struct {
+	Field string
+	Op    string
+	Value string
+
+	re *regexp.Regexp
+}
+
+// operators, longest first so ">=" is not parsed as ">".
+var operators = []string{">=", "<=", "!=", "=", ">", "<", "~"}
+
+// ParseCondition parses a condition in the form <field><op><value>, where op is one of
+// =, !=, >, >=, <, <= or ~ (regular expression match).
+func ParseCondition(expr string) (Condition, error) {
+	for i := 0; i < len(expr); i++ {
+		for _, op := range operators {
+			if !strings.HasPrefix(expr[i:], op) {
+				continue
+			}
+			c := Condition{
+				Field: strings.TrimSpace(expr[:i]),
+				Op:    op,
+				Value: strings.TrimSpace(expr[i+len(op):]),
+			}
+			if c.Field == "" {
+				return Condition{}, fmt.Errorf("missing field name in %q", expr)
+			}
+			if op == "~" {
+				re, err := regexp.Compile(c.Value)
+				if err != nil {
+					return Condition{}, fmt.Errorf("invalid regular expression in %q: %w", expr, err)
+				}
+				c.re = re
+			}
+			return c, nil
+		}
+	}
+	return Condition{}, fmt.Errorf("invalid condition %q. expected <field><op><value> with op one of %s", expr, strings.Join(operators, " "))
+}
+
+// Match returns true if the fields match the condition. Missing fields only match "!=".
+// Ordering operators compare numbers if both sides are numbers, and strings otherwise.
+func (c Condition) Match(fields Fields) bool {
+	value, ok := fields[c.Field]
+	if !ok {
+		return c.Op == "!="
+	}
+	switch c.Op {
+	case "=":
+		return value == c.Value
+	case "!=":
+		return value != c.Value
+	case "~":
+		return c.re.MatchString(value)
+	}
+
+	cmp := strings.Compare(value, c.Value)
+	a, errA := strconv.ParseFloat(value, 64)
+	b, errB := strconv.ParseFloat(c.Value, 64)
+	if errA == nil && errB == nil {
+		switch {
+		case a < b:
+			cmp = -1
+		case a > b:
+			cmp = 1
+		default:
+			cmp = 0
+		}
+	}
+	switch c.Op {
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	}
+	return false
+}
+
+// LineProcessor parses, filters and projects log lines before they are printed.
+type LineProcessor struct {
+	Format Format
+	// Where conditions must all match for a line to be printed. Lines that can't be parsed don't
+	// match any condition.
+	Where []Condition
+	// Fields to print, instead of the whole line. Lines that can't be parsed are printed as is.
+	Fields []string
+}
+
+// Process returns the line to print, or false if the line is filtered out.
+func (p *LineProcessor) Process(line string) (string, bool) {
+	fields, ok := p.Format.Parse(line)
+	if !ok {
+		return line, len(p.Where) == 0
+	}
+	for _, c := range p.Where {
+		if !c.Match(fields) {
+			return "", false
+		}
+	}
+	if len(p.Fields) == 0 {
+		return line, true
+	}
+
+	var buf bytes.Buffer
+	for _, name := range p.Fields {
+		value, ok := fields[name]
+		if !ok {
+			continue
+		}
+		if buf.Len() != 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(name)
+		buf.WriteByte('=')
+		if value == "" || strings.ContainsAny(value, " \"=\t") {
+			value = strconv.Quote(value)
+		}
+		buf.WriteString(value)
+	}
+	return buf.String(), true
+}
@@ -0,0 +1,115 @@
+package logs_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/solo-io/kdiag/pkg/logs"
+)
+
+var _ = Describe("Parse", func() {
+	It("should flatten json lines", func() {
+		fields, ok := logs.FormatJSON.Parse(`{"level":"error","msg":"failed","http":{"code":503},"ok":false,"tags":["a"]}`)
+		Expect(ok).To(BeTrue())
+		Expect(fields).To(Equal(logs.Fields{"level": "error", "msg": "failed", "http.code": "503", "ok": "false", "tags": `["a"]`}))
+
+		_, ok = logs.FormatJSON.Parse("starting server")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should parse logfmt lines", func() {
+		fields, ok := logs.FormatLogfmt.Parse(`level=info msg="request done" status=200 trace.id= http/path=/api`)
+		Expect(ok).To(BeTrue())
+		Expect(fields).To(Equal(logs.Fields{"level": "info", "msg": "request done", "status": "200", "trace.id": "", "http/path": "/api"}))
+	})
+
+	DescribeTable("should not parse free text as logfmt",
+		func(line string) {
+			_, ok := logs.FormatLogfmt.Parse(line)
+			Expect(ok).To(BeFalse())
+		},
+		Entry("words", "starting server"),
+		Entry("words with a pair", "error: retry count=3 exceeded"),
+		Entry("pairs with a word", "level=info msg=done debug"),
+		Entry("invalid key", `error:=x level=info`),
+		Entry("empty key", "=x level=info"),
+		Entry("unterminated quote", `level=info msg="done`),
+		Entry("empty", ""),
+	)
+
+	It("should parse istio access logs", func() {
+		line := `[2022-05-01T10:00:00.000Z] "GET /productpage HTTP/1.1" 503 UF upstream_reset_before_response_started{connection_failure} - "-" 0 91 2 - "-" "curl/7.83.0" "6f5c1c5e-4c1b-9d4b-a6a0-2c1d52c7e1b0" "productpage:9080" "10.244.0.12:9080" outbound|9080||productpage.bookinfo.svc.cluster.local - 10.96.10.10:9080 10.244.0.10:41344 - default`
+		fields, ok := logs.FormatEnvoy.Parse(line)
+		Expect(ok).To(BeTrue())
+		Expect(fields).To(HaveKeyWithValue("method", "GET"))
+		Expect(fields).To(HaveKeyWithValue("path", "/productpage"))
+		Expect(fields).To(HaveKeyWithValue("response_code", "503"))
+		Expect(fields).To(HaveKeyWithValue("response_flags", "UF"))
+		Expect(fields).To(HaveKeyWithValue("request_id", "6f5c1c5e-4c1b-9d4b-a6a0-2c1d52c7e1b0"))
+		Expect(fields).To(HaveKeyWithValue("upstream_cluster", "outbound|9080||productpage.bookinfo.svc.cluster.local"))
+		Expect(fields).To(HaveKeyWithValue("route_name", "default"))
+		Expect(fields).NotTo(HaveKey("upstream_service_time"))
+	})
+
+	It("should parse default envoy access logs", func() {
+		line := `[2022-05-01T10:00:00.000Z] "POST /api HTTP/2" 200 - 10 20 3 2 "-" "grpc-go" "id-1" "svc" "10.0.0.1:80"`
+		fields, ok := logs.FormatEnvoy.Parse(line)
+		Expect(ok).To(BeTrue())
+		Expect(fields).To(HaveKeyWithValue("method", "POST"))
+		Expect(fields).To(HaveKeyWithValue("duration", "3"))
+		Expect(fields).To(HaveKeyWithValue("upstream_host", "10.0.0.1:80"))
+	})
+})
+
+var _ = Describe("Condition", func() {
+	fields := logs.Fields{"level": "error", "response_code": "503", "path": "/api/v1"}
+
+	DescribeTable("should match fields",
+		func(expr string, match bool) {
+			c, err := logs.ParseCondition(expr)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.Match(fields)).To(Equal(match))
+		},
+		Entry("equal", "level=error", true),
+		Entry("not equal", "level!=error", false),
+		Entry("numeric greater or equal", "response_code>=500", true),
+		Entry("numeric less than", "response_code<500", false),
+		Entry("numeric compare is not lexical", "response_code>60", true),
+		Entry("regex", "path~^/api/", true),
+		Entry("missing field", "user=admin", false),
+		Entry("missing field not equal", "user!=admin", true),
+	)
+
+	It("should reject invalid conditions", func() {
+		_, err := logs.ParseCondition("level")
+		Expect(err).To(HaveOccurred())
+		_, err = logs.ParseCondition("=error")
+		Expect(err).To(HaveOccurred())
+		_, err = logs.ParseCondition("path~(")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("LineProcessor", func() {
+	It("should filter and project lines", func() {
+		where, err := logs.ParseCondition("level=error")
+		Expect(err).NotTo(HaveOccurred())
+		p := &logs.LineProcessor{Format: logs.FormatJSON, Where: []logs.Condition{where}, Fields: []string{"msg", "code"}}
+
+		line, ok := p.Process(`{"level":"error","msg":"upstream failed","code":503}`)
+		Expect(ok).To(BeTrue())
+		Expect(line).To(Equal(`msg="upstream failed" code=503`))
+
+		_, ok = p.Process(`{"level":"info","msg":"ok"}`)
+		Expect(ok).To(BeFalse())
+		_, ok = p.Process("not json")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should print unparsed lines without conditions", func() {
+		p := &logs.LineProcessor{Format: logs.FormatJSON, Fields: []string{"msg"}}
+		line, ok := p.Process("not json")
+		Expect(ok).To(BeTrue())
+		Expect(line).To(Equal("not json"))
+	})
+})
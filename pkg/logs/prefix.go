@@ -0,0 +1,69 @@
+package logs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/fatih/color"
+)
+
+// printLine prints a line prefixed with the colored name of its source.
+func printLine(out io.Writer, c *color.Color, name, line string) {
+	c.Fprintf(out, "%s:", name)
+	fmt.Fprintf(out, " %s\n", line)
+}
+
+// PrefixPrinter prints the output of multiple sources line by line, prefixed with the colored name
+// of the source, in the same style as MultiLogPrinter. Lines from different sources don't interleave.
+type PrefixPrinter struct {
+	lock sync.Mutex
+}
+
+// Writer returns a writer for the i-th source, that prints to out.
+// Call Flush when done writing to print the last line if it doesn't end with a newline.
+func (p *PrefixPrinter) Writer(out io.Writer, i int, name string) *PrefixWriter {
+	return &PrefixWriter{
+		printer: p,
+		out:     out,
+		color:   pallete(i),
+		name:    name,
+	}
+}
+
+type PrefixWriter struct {
+	printer *PrefixPrinter
+	out     io.Writer
+	color   *color.Color
+	name    string
+	// an incomplete line, waiting for its newline.
+	pending []byte
+}
+
+func (w *PrefixWriter) Write(b []byte) (int, error) {
+	w.pending = append(w.pending, b...)
+	for {
+		index := bytes.IndexByte(w.pending, '\n')
+		if index < 0 {
+			break
+		}
+		w.print(string(w.pending[:index]))
+		w.pending = w.pending[index+1:]
+	}
+	return len(b), nil
+}
+
+// Flush prints the pending incomplete line, if any.
+func (w *PrefixWriter) Flush() {
+	if len(w.pending) != 0 {
+		w.print(string(w.pending))
+		w.pending = nil
+	}
+}
+
+func (w *PrefixWriter) print(line string) {
+	w.printer.lock.Lock()
+	defer w.printer.lock.Unlock()
+	printLine(w.out, w.color, w.name, line)
+}
@@ -0,0 +1,84 @@
+package logs_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/solo-io/kdiag/pkg/logs"
+)
+
+var _ = Describe("PrefixPrinter", func() {
+	var (
+		noColor bool
+		printer *logs.PrefixPrinter
+		out     *bytes.Buffer
+	)
+	BeforeEach(func() {
+		noColor = color.NoColor
+		color.NoColor = true
+		printer = &logs.PrefixPrinter{}
+		out = &bytes.Buffer{}
+	})
+	AfterEach(func() {
+		color.NoColor = noColor
+	})
+
+	It("should print complete lines only", func() {
+		w := printer.Writer(out, 0, "pod1")
+		w.Write([]byte("hel"))
+		Expect(out.String()).To(BeEmpty())
+		w.Write([]byte("lo\nwor"))
+		Expect(out.String()).To(Equal("pod1: hello\n"))
+		w.Write([]byte("ld\n\nlast\n"))
+		Expect(out.String()).To(Equal("pod1: hello\npod1: world\npod1: \npod1: last\n"))
+	})
+
+	It("should print the partial last line on flush", func() {
+		w := printer.Writer(out, 0, "pod1")
+		w.Write([]byte("line\npartial"))
+		w.Flush()
+		Expect(out.String()).To(Equal("pod1: line\npod1: partial\n"))
+		// there is nothing left to flush.
+		w.Flush()
+		Expect(out.String()).To(Equal("pod1: line\npod1: partial\n"))
+	})
+
+	It("should not interleave lines of concurrent writers", func() {
+		const writers, lines = 10, 100
+		var wg sync.WaitGroup
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				w := printer.Writer(out, i, fmt.Sprintf("pod%d", i))
+				defer w.Flush()
+				for j := 0; j < lines; j++ {
+					// split the lines across writes, so incomplete lines are pending.
+					w.Write([]byte(fmt.Sprintf("line %d of ", j)))
+					w.Write([]byte(fmt.Sprintf("pod%d\n", i)))
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		printed := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+		Expect(printed).To(HaveLen(writers * lines))
+		next := map[string]int{}
+		for _, line := range printed {
+			var j int
+			var name, source string
+			_, err := fmt.Sscanf(line, "%s line %d of %s", &name, &j, &source)
+			Expect(err).NotTo(HaveOccurred(), line)
+			Expect(name).To(Equal(source + ":"))
+			// the lines of each writer are in order.
+			Expect(j).To(Equal(next[source]))
+			next[source]++
+		}
+	})
+})
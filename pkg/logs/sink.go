@@ -0,0 +1,121 @@
+package logs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Output is the format log lines are printed in.
+type Output string
+
+const (
+	// OutputText prints the lines prefixed with the colored name of their pod.
+	OutputText Output = "text"
+	// OutputJSONL prints a json Record per line.
+	OutputJSONL Output = "jsonl"
+)
+
+func ParseOutput(s string) (Output, error) {
+	switch o := Output(s); o {
+	case OutputText, OutputJSONL:
+		return o, nil
+	}
+	return "", fmt.Errorf("unknown output format %q. must be text or jsonl", s)
+}
+
+// Record is a log line in jsonl output.
+type Record struct {
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	Namespace string `json:"namespace"`
+	// Timestamp is when the line was logged. nil if kubernetes didn't report it.
+	Timestamp *time.Time `json:"timestamp"`
+	Line      string     `json:"line"`
+}
+
+func (m *MultiLogPrinter) record(entry logEntry) Record {
+	r := Record{
+		Pod:       entry.source.PodName,
+		Container: entry.source.ContainerName,
+		Namespace: m.namespaceOf(entry.source),
+		Line:      entry.log,
+	}
+	if !entry.timestamp.IsZero() {
+		timestamp := entry.timestamp.UTC()
+		r.Timestamp = &timestamp
+	}
+	return r
+}
+
+// writeRecord writes the record as a single json line.
+func writeRecord(out io.Writer, r Record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(append(b, '\n'))
+	return err
+}
+
+// fileSink writes the lines of each pod and container to a file of its own. It is only used by the
+// print loop, so it needs no locking.
+type fileSink struct {
+	dir    string
+	output Output
+	// nil for files that failed, so the error is only reported once.
+	files map[PodAndContainerName]*os.File
+}
+
+func newFileSink(dir string, output Output) *fileSink {
+	return &fileSink{dir: dir, output: output, files: map[PodAndContainerName]*os.File{}}
+}
+
+// fileName returns the name of the file of a pod and container, e.g. "productpage-v1-xyz_istio-proxy.log",
+// prefixed by the namespace if the source has one.
+func (s *fileSink) fileName(source PodAndContainerName) string {
+	name := source.PodName
+	if source.Namespace != "" {
+		name = source.Namespace + "_" + name
+	}
+	if source.ContainerName != "" {
+		name += "_" + source.ContainerName
+	}
+	if s.output == OutputJSONL {
+		return name + ".jsonl"
+	}
+	return name + ".log"
+}
+
+// file returns the file of the source, creating it on first use.
+func (s *fileSink) file(source PodAndContainerName) (*os.File, error) {
+	if f, ok := s.files[source]; ok {
+		if f == nil {
+			return nil, nil
+		}
+		return f, nil
+	}
+	f, err := os.OpenFile(filepath.Join(s.dir, s.fileName(source)), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		s.files[source] = nil
+		return nil, fmt.Errorf("failed to create log file: %w", err)
+	}
+	s.files[source] = f
+	return f, nil
+}
+
+func (s *fileSink) Close() error {
+	var err error
+	for _, f := range s.files {
+		if f == nil {
+			continue
+		}
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
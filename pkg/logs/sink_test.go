@@ -0,0 +1,84 @@
+package logs_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/solo-io/kdiag/pkg/logs"
+)
+
+var _ = Describe("Output", func() {
+	var (
+		pods        = fake.NewSimpleClientset(runningPod("pod1", "id1")).CoreV1()
+		podNames    = []logs.PodAndContainerName{{PodName: "pod1"}}
+		out, errOut *bytes.Buffer
+	)
+	BeforeEach(func() {
+		out, errOut = &bytes.Buffer{}, &bytes.Buffer{}
+	})
+
+	It("should print json lines", func() {
+		printer := logs.MultiLogPrinter{Out: out, ErrOut: errOut, Output: logs.OutputJSONL, Namespace: "default"}
+		Expect(printer.PrintLogs(context.Background(), pods, podNames)).To(Succeed())
+		// the default container is resolved, and the fake logs have no timestamps.
+		Expect(out.String()).To(Equal(`{"pod":"pod1","container":"app","namespace":"default","timestamp":null,"line":"fake logs"}` + "\n"))
+		Expect(errOut.String()).To(Equal("pod pod1 is done\n"))
+	})
+
+	It("should show the namespace of pods that have one", func() {
+		printer := logs.MultiLogPrinter{Out: out, ErrOut: errOut, Output: logs.OutputJSONL, Namespace: "other"}
+		names := []logs.PodAndContainerName{{Namespace: "default", PodName: "pod1", ContainerName: "app"}}
+		Expect(printer.PrintLogs(context.Background(), pods, names)).To(Succeed())
+		Expect(out.String()).To(Equal(`{"pod":"pod1","container":"app","namespace":"default","timestamp":null,"line":"fake logs"}` + "\n"))
+		Expect(errOut.String()).To(Equal("pod default/pod1:app is done\n"))
+	})
+
+	It("should write a file per pod and container", func() {
+		dir := GinkgoT().TempDir()
+		printer := logs.MultiLogPrinter{Out: out, ErrOut: errOut, Namespace: "default", OutputDir: dir, Quiet: true}
+		Expect(printer.PrintLogs(context.Background(), pods, podNames)).To(Succeed())
+		Expect(out.String()).To(Equal("pod pod1 is done\n"))
+
+		data, err := os.ReadFile(filepath.Join(dir, "pod1_app.log"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("fake logs\n"))
+	})
+
+	It("should write json lines files", func() {
+		dir := GinkgoT().TempDir()
+		printer := logs.MultiLogPrinter{Out: out, ErrOut: errOut, Namespace: "default", Output: logs.OutputJSONL, OutputDir: dir}
+		Expect(printer.PrintLogs(context.Background(), pods, podNames)).To(Succeed())
+		Expect(out.String()).To(ContainSubstring(`"line":"fake logs"`))
+
+		data, err := os.ReadFile(filepath.Join(dir, "pod1_app.jsonl"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal(out.String()))
+	})
+
+	It("should return when the snapshot is printed", func() {
+		printer := logs.MultiLogPrinter{Out: out, ErrOut: errOut, Snapshot: true}
+		Expect(printer.PrintLogs(context.Background(), pods, podNames)).To(Succeed())
+		Expect(out.String()).To(Equal("pod1: fake logs\npod pod1 is done\n"))
+	})
+
+	It("should fail if a line matches a fail-on pattern", func() {
+		printer := logs.MultiLogPrinter{Out: out, ErrOut: errOut, Snapshot: true, FailOn: []*regexp.Regexp{regexp.MustCompile("fake")}}
+		err := printer.PrintLogs(context.Background(), pods, podNames)
+		Expect(err).To(Equal(&logs.FailOnError{Matches: 1, PodName: "pod1", Line: "fake logs"}))
+
+		printer.FailOn = []*regexp.Regexp{regexp.MustCompile("error")}
+		Expect(printer.PrintLogs(context.Background(), pods, podNames)).To(Succeed())
+	})
+
+	It("should reject unknown formats", func() {
+		_, err := logs.ParseOutput("yaml")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,222 @@
+package logs
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+const (
+	// DefaultMaxRequests is the default number of requests to open log streams that are in flight
+	// at once.
+	DefaultMaxRequests = 10
+	// DefaultRetryBackoff is the default delay before the first retry of a failed stream. it doubles
+	// on every retry, up to maxRetryBackoff.
+	DefaultRetryBackoff = time.Second
+	maxRetryBackoff     = 30 * time.Second
+)
+
+// streamRequest is a log stream to follow.
+type streamRequest struct {
+	// the name the lines are printed with.
+	podName string
+	// the pod and container the lines come from. an empty container is resolved to the default
+	// container if resolveContainer is set.
+	source           PodAndContainerName
+	resolveContainer bool
+	podclient        typedcorev1.PodInterface
+	opts             *corev1.PodLogOptions
+	// called when the stream failed and won't be retried.
+	onFail func()
+
+	started bool
+	// when reconnecting, lines logged up to this time were already read.
+	resumeAfter time.Time
+}
+
+// streamFailure is a stream that failed, for the summary.
+type streamFailure struct {
+	podName string
+	err     error
+}
+
+// follow reads the lines of a log stream until it ends, reconnecting with backoff if it fails.
+// returns false if the pipeline is already stopped.
+func (p *logPipeline) follow(r *streamRequest) bool {
+	p.lock.Lock()
+	if p.stopped {
+		p.lock.Unlock()
+		return false
+	}
+	p.wg.Add(1)
+	p.streams++
+	p.lock.Unlock()
+
+	podNameColor := p.colorFor(r.podName)
+	go func() {
+		defer p.wg.Done()
+		// entries without a timestamp of their own are ordered after the previous line.
+		var lastTimestamp time.Time
+		for retry := 0; ; retry++ {
+			progress, err := p.read(r, podNameColor, &lastTimestamp)
+			if err == nil {
+				p.entries <- logEntry{podName: r.podName, color: podNameColor, done: true, timestamp: lastTimestamp, arrival: time.Now()}
+				return
+			}
+			if p.ctx.Err() != nil {
+				return
+			}
+			if progress {
+				// the stream worked for a while, so this is a new failure.
+				retry = 0
+			}
+			if !retryable(err) || retry >= p.retries {
+				p.fail(r, podNameColor, err)
+				return
+			}
+			delay := p.retryBackoff << retry
+			if delay > maxRetryBackoff || delay <= 0 {
+				delay = maxRetryBackoff
+			}
+			p.entries <- logEntry{podName: r.podName, color: podNameColor, err: fmt.Errorf("%w. retrying in %v", err, delay)}
+			select {
+			case <-p.ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			r.resume(lastTimestamp)
+		}
+	}()
+	return true
+}
+
+// read opens the stream and sends its lines to the print loop. returns nil when the stream ended,
+// and whether any line was read.
+func (p *logPipeline) read(r *streamRequest, podNameColor *color.Color, lastTimestamp *time.Time) (bool, error) {
+	readCloser, err := p.open(r)
+	if err != nil {
+		return false, fmt.Errorf("failed to stream logs: %w", err)
+	}
+	defer readCloser.Close()
+	if p.watch && !r.started {
+		p.entries <- logEntry{podName: r.podName, color: podNameColor, started: true, arrival: time.Now()}
+	}
+	r.started = true
+
+	progress := false
+	reader := bufio.NewReader(readCloser)
+	for {
+		bytes, err := reader.ReadBytes('\n')
+
+		if len(bytes) != 0 {
+			progress = true
+			timestamp, logline := splitTimestamp(strings.TrimSuffix(string(bytes), "\n"), *lastTimestamp)
+			*lastTimestamp = timestamp
+			if !r.resumeAfter.IsZero() && !timestamp.After(r.resumeAfter) {
+				// we printed this line before we reconnected.
+				continue
+			}
+			p.send(r, podNameColor, timestamp, logline)
+		}
+		if err == io.EOF {
+			return progress, nil
+		}
+		if err != nil {
+			return progress, fmt.Errorf("failed to read logs: %w", err)
+		}
+	}
+}
+
+// send sends a line to the print loop, unless it is filtered out.
+func (p *logPipeline) send(r *streamRequest, podNameColor *color.Color, timestamp time.Time, logline string) {
+	// the whole line is checked, even if it is filtered out.
+	p.checkFailOn(r.podName, logline)
+	if p.trace != nil {
+		p.trace.observe(r.podName, podNameColor, timestamp, logline)
+		return
+	}
+	if p.processor != nil {
+		var keep bool
+		if logline, keep = p.processor.Process(logline); !keep {
+			return
+		}
+	}
+	p.entries <- logEntry{podName: r.podName, source: r.source, color: podNameColor, log: logline, timestamp: timestamp, arrival: time.Now()}
+}
+
+// open opens the stream. the number of requests in flight is bounded, so following many pods
+// doesn't flood the api server.
+func (p *logPipeline) open(r *streamRequest) (io.ReadCloser, error) {
+	select {
+	case p.requests <- struct{}{}:
+	case <-p.ctx.Done():
+		return nil, p.ctx.Err()
+	}
+	defer func() { <-p.requests }()
+
+	if r.resolveContainer && r.source.ContainerName == "" {
+		// the records need the actual name of the default container.
+		pod, err := r.podclient.Get(p.ctx, r.source.PodName, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if len(pod.Spec.Containers) != 0 {
+			r.source.ContainerName = pod.Spec.Containers[0].Name
+		}
+	}
+	return r.podclient.GetLogs(r.source.PodName, r.opts).Stream(p.ctx)
+}
+
+// resume changes the request to continue from the last line that was read.
+func (r *streamRequest) resume(lastTimestamp time.Time) {
+	if !r.started {
+		// the stream never opened, so the original request is still right.
+		return
+	}
+	opts := *r.opts
+	opts.TailLines, opts.SinceSeconds, opts.SinceTime = nil, nil, nil
+	if lastTimestamp.IsZero() {
+		// no lines were read yet. there is no way to tell which lines were logged since, so only
+		// new lines are followed.
+		zero := int64(0)
+		opts.TailLines = &zero
+	} else {
+		// the api only has a precision of seconds. the lines we already read are skipped.
+		sinceTime := metav1.NewTime(lastTimestamp)
+		opts.SinceTime = &sinceTime
+		r.resumeAfter = lastTimestamp
+	}
+	r.opts = &opts
+}
+
+// fail reports a stream that won't be retried.
+func (p *logPipeline) fail(r *streamRequest, podNameColor *color.Color, err error) {
+	p.lock.Lock()
+	p.failures = append(p.failures, streamFailure{podName: r.podName, err: err})
+	p.lock.Unlock()
+	p.entries <- logEntry{podName: r.podName, color: podNameColor, err: err}
+	if r.onFail != nil {
+		r.onFail()
+	}
+}
+
+// retryable returns false for errors that won't go away by retrying, like a missing pod or container.
+func retryable(err error) bool {
+	switch {
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	case apierrors.IsNotFound(err), apierrors.IsBadRequest(err), apierrors.IsForbidden(err),
+		apierrors.IsUnauthorized(err), apierrors.IsInvalid(err), apierrors.IsMethodNotSupported(err):
+		return false
+	}
+	return true
+}
@@ -0,0 +1,151 @@
+package logs_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	restclient "k8s.io/client-go/rest"
+	fakerest "k8s.io/client-go/rest/fake"
+
+	"github.com/solo-io/kdiag/pkg/logs"
+)
+
+// flakyPods serves logs with a function of the test.
+type flakyPods struct {
+	typedcorev1.PodInterface
+	logs func(name string, opts *corev1.PodLogOptions) (*http.Response, error)
+}
+
+func (p *flakyPods) Pods(string) typedcorev1.PodInterface {
+	return p
+}
+
+func (p *flakyPods) GetLogs(name string, opts *corev1.PodLogOptions) *restclient.Request {
+	client := &fakerest.RESTClient{
+		Client: fakerest.CreateHTTPClient(func(*http.Request) (*http.Response, error) {
+			return p.logs(name, opts)
+		}),
+		NegotiatedSerializer: scheme.Codecs.WithoutConversion(),
+	}
+	return client.Request()
+}
+
+func response(code int, body string) *http.Response {
+	return &http.Response{StatusCode: code, Body: io.NopCloser(strings.NewReader(body))}
+}
+
+var _ = Describe("Streams", func() {
+	var (
+		out, errOut *bytes.Buffer
+		printer     logs.MultiLogPrinter
+	)
+	BeforeEach(func() {
+		out, errOut = &bytes.Buffer{}, &bytes.Buffer{}
+		printer = logs.MultiLogPrinter{Out: out, ErrOut: errOut, Snapshot: true, Retries: 2, RetryBackoff: time.Millisecond}
+	})
+	flaky := func(f func(name string, opts *corev1.PodLogOptions) (*http.Response, error)) *flakyPods {
+		return &flakyPods{PodInterface: fake.NewSimpleClientset().CoreV1().Pods("default"), logs: f}
+	}
+
+	It("should retry failed streams", func() {
+		attempts := 0
+		pods := flaky(func(string, *corev1.PodLogOptions) (*http.Response, error) {
+			if attempts++; attempts < 3 {
+				return response(http.StatusServiceUnavailable, "unavailable"), nil
+			}
+			return response(http.StatusOK, "2022-05-01T10:00:00Z hello\n"), nil
+		})
+		Expect(printer.PrintLogs(context.Background(), pods, []logs.PodAndContainerName{{PodName: "pod1"}})).To(Succeed())
+		Expect(out.String()).To(Equal("pod1: hello\npod pod1 is done\n"))
+		Expect(errOut.String()).To(ContainSubstring("retrying in 1ms"))
+		Expect(errOut.String()).To(ContainSubstring("retrying in 2ms"))
+	})
+
+	It("should reconnect from the last line read", func() {
+		var requests []*corev1.PodLogOptions
+		pods := flaky(func(_ string, opts *corev1.PodLogOptions) (*http.Response, error) {
+			requests = append(requests, opts)
+			if len(requests) == 1 {
+				return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(io.MultiReader(
+					strings.NewReader("2022-05-01T10:00:00.1Z one\n2022-05-01T10:00:00.2Z two\n"),
+					&failingReader{},
+				))}, nil
+			}
+			// the api returns the lines from the start of the second.
+			return response(http.StatusOK, "2022-05-01T10:00:00.1Z one\n2022-05-01T10:00:00.2Z two\n2022-05-01T10:00:00.3Z three\n"), nil
+		})
+		Expect(printer.PrintLogs(context.Background(), pods, []logs.PodAndContainerName{{PodName: "pod1"}})).To(Succeed())
+		Expect(out.String()).To(Equal("pod1: one\npod1: two\npod1: three\npod pod1 is done\n"))
+		Expect(requests).To(HaveLen(2))
+		Expect(requests[1].SinceTime.Time).To(Equal(time.Date(2022, 5, 1, 10, 0, 0, 200000000, time.UTC)))
+	})
+
+	It("should report the failed streams without stopping the others", func() {
+		pods := flaky(func(name string, _ *corev1.PodLogOptions) (*http.Response, error) {
+			if name == "missing" {
+				return response(http.StatusNotFound, "not found"), nil
+			}
+			return response(http.StatusOK, "hello\n"), nil
+		})
+		podNames := []logs.PodAndContainerName{{PodName: "pod1"}, {PodName: "missing"}}
+		Expect(printer.PrintLogs(context.Background(), pods, podNames)).To(Succeed())
+		Expect(out.String()).To(Equal("pod1: hello\npod pod1 is done\n"))
+		// missing pods are not retried.
+		Expect(errOut.String()).NotTo(ContainSubstring("retrying"))
+		Expect(errOut.String()).To(ContainSubstring("1 of 2 log streams failed:\n  missing: failed to stream logs:"))
+	})
+
+	It("should fail if all the streams failed", func() {
+		pods := flaky(func(string, *corev1.PodLogOptions) (*http.Response, error) {
+			return response(http.StatusInternalServerError, "error"), nil
+		})
+		err := printer.PrintLogs(context.Background(), pods, []logs.PodAndContainerName{{PodName: "pod1"}})
+		Expect(err).To(MatchError("all 1 log streams failed"))
+		Expect(strings.Count(errOut.String(), "retrying")).To(Equal(2))
+	})
+
+	It("should bound the requests in flight", func() {
+		var lock sync.Mutex
+		inFlight, maxInFlight := 0, 0
+		pods := flaky(func(string, *corev1.PodLogOptions) (*http.Response, error) {
+			lock.Lock()
+			inFlight++
+			if inFlight > maxInFlight {
+				maxInFlight = inFlight
+			}
+			lock.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			lock.Lock()
+			inFlight--
+			lock.Unlock()
+			return response(http.StatusOK, "hello\n"), nil
+		})
+		var podNames []logs.PodAndContainerName
+		for i := 0; i < 20; i++ {
+			podNames = append(podNames, logs.PodAndContainerName{PodName: fmt.Sprintf("pod%d", i)})
+		}
+		printer.MaxRequests = 3
+		Expect(printer.PrintLogs(context.Background(), pods, podNames)).To(Succeed())
+		Expect(strings.Count(out.String(), "hello")).To(Equal(20))
+		Expect(maxInFlight).To(Equal(3))
+	})
+})
+
+// failingReader fails like a connection that dropped.
+type failingReader struct{}
+
+func (*failingReader) Read([]byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
@@ -0,0 +1,144 @@
+package logs
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// RequestIDHeader is the header envoy uses to correlate the access logs of a request.
+const RequestIDHeader = "x-request-id"
+
+// RequestIDEnv is set in the environment of the user command to the id of the traced request, so
+// commands other than curl can send it.
+const RequestIDEnv = "KDIAG_REQUEST_ID"
+
+// requestIDFields are the fields that hold the request id in parsed log lines.
+var requestIDFields = []string{"request_id", "x_request_id", "x-request-id", "requestId", "requestID"}
+
+// InjectRequestID adds the request id header to a curl command. Other commands are returned as is.
+func InjectRequestID(args []string, id string) []string {
+	if len(args) == 0 || filepath.Base(args[0]) != "curl" {
+		return args
+	}
+	injected := []string{args[0], "-H", RequestIDHeader + ": " + id}
+	return append(injected, args[1:]...)
+}
+
+// RequestTrace collects the log lines that mention a request id, and prints them as a timeline of the
+// hops the request made.
+type RequestTrace struct {
+	// ID of the request. when empty, the first request id found in a parsed log line is used.
+	ID string
+
+	lock sync.Mutex
+	hops []hop
+}
+
+type hop struct {
+	podName   string
+	color     *color.Color
+	timestamp time.Time
+	line      string
+	fields    Fields
+}
+
+func parseAny(line string) (Fields, bool) {
+	for _, f := range []Format{FormatEnvoy, FormatJSON} {
+		if fields, ok := f.Parse(line); ok {
+			return fields, true
+		}
+	}
+	return nil, false
+}
+
+func requestID(fields Fields) string {
+	for _, name := range requestIDFields {
+		if id := fields[name]; id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// observe records the line if it belongs to the traced request.
+func (t *RequestTrace) observe(podName string, c *color.Color, timestamp time.Time, line string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	fields, parsed := parseAny(line)
+	if t.ID == "" {
+		if !parsed {
+			return
+		}
+		if t.ID = requestID(fields); t.ID == "" {
+			return
+		}
+	} else if !strings.Contains(line, t.ID) {
+		return
+	}
+	t.hops = append(t.hops, hop{podName: podName, color: c, timestamp: timestamp, line: line, fields: fields})
+}
+
+// Print prints the hops of the request ordered by time, relative to the first one.
+func (t *RequestTrace) Print(out, errOut io.Writer) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.ID == "" {
+		fmt.Fprintln(errOut, "no request id found in the logs")
+		return
+	}
+	if len(t.hops) == 0 {
+		fmt.Fprintf(errOut, "no log lines found for request %s\n", t.ID)
+		return
+	}
+
+	// envoy logs when the request completes, so order by when it started if we know it.
+	for i := range t.hops {
+		if start, err := time.Parse(time.RFC3339Nano, t.hops[i].fields["start_time"]); err == nil {
+			t.hops[i].timestamp = start
+		}
+	}
+	sort.SliceStable(t.hops, func(i, j int) bool {
+		return t.hops[i].timestamp.Before(t.hops[j].timestamp)
+	})
+
+	fmt.Fprintf(out, "request %s: %d hops\n", t.ID, len(t.hops))
+	first := t.hops[0].timestamp
+	for _, h := range t.hops {
+		offset := "?"
+		if !h.timestamp.IsZero() && !first.IsZero() {
+			offset = "+" + h.timestamp.Sub(first).String()
+		}
+		printLine(out, h.color, h.podName, fmt.Sprintf("%-10s %s", offset, h.summary()))
+	}
+}
+
+// summary describes an access log hop with its most useful fields, and other lines as is.
+func (h *hop) summary() string {
+	if h.fields["response_code"] == "" {
+		return h.line
+	}
+	var parts []string
+	for _, name := range []string{"method", "path", "response_code", "response_flags"} {
+		if value := h.fields[name]; value != "" {
+			parts = append(parts, value)
+		}
+	}
+	if duration := h.fields["duration"]; duration != "" {
+		parts = append(parts, "duration="+duration+"ms")
+	}
+	if cluster := h.fields["upstream_cluster"]; cluster != "" {
+		parts = append(parts, "upstream="+cluster)
+	} else if host := h.fields["upstream_host"]; host != "" {
+		parts = append(parts, "upstream="+host)
+	}
+	return strings.Join(parts, " ")
+}
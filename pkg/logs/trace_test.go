@@ -0,0 +1,39 @@
+package logs
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/fatih/color"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RequestTrace", func() {
+	It("should inject the request id to curl commands", func() {
+		Expect(InjectRequestID([]string{"/usr/bin/curl", "http://foo"}, "id")).To(Equal([]string{"/usr/bin/curl", "-H", "x-request-id: id", "http://foo"}))
+		Expect(InjectRequestID([]string{"wget", "http://foo"}, "id")).To(Equal([]string{"wget", "http://foo"}))
+	})
+
+	It("should print the hops of the request as a timeline", func() {
+		t := &RequestTrace{}
+		c := color.New()
+		base := time.Date(2022, 5, 1, 10, 0, 0, 0, time.UTC)
+		t.observe("other", c, base, "unrelated line")
+		// the first id found is traced. envoy logs when the request ends, so the hops are ordered by
+		// their start time.
+		t.observe("productpage:istio-proxy", c, base.Add(16*time.Millisecond), `[2022-05-01T10:00:00.010Z] "GET /reviews HTTP/1.1" 200 - 0 10 5 4 "-" "curl" "id-1" "reviews:9080" "10.0.0.2:9080"`)
+		t.observe("ingress", c, base.Add(25*time.Millisecond), `[2022-05-01T10:00:00.000Z] "GET /productpage HTTP/1.1" 200 - 0 100 20 19 "-" "curl" "id-1" "productpage:9080" "10.0.0.1:9080"`)
+		t.observe("productpage:app", c, base.Add(12*time.Millisecond), `handling request id-1`)
+		t.observe("reviews:istio-proxy", c, base.Add(30*time.Millisecond), `[2022-05-01T10:00:00.020Z] "GET /reviews HTTP/1.1" 200 - 0 10 5 4 "-" "curl" "id-2" "reviews:9080" "10.0.0.2:9080"`)
+
+		out := &bytes.Buffer{}
+		t.Print(out, out)
+		Expect(t.ID).To(Equal("id-1"))
+		Expect(out.String()).To(Equal(`request id-1: 3 hops
+ingress: +0s        GET /productpage 200 duration=20ms upstream=10.0.0.1:9080
+productpage:istio-proxy: +10ms      GET /reviews 200 duration=5ms upstream=10.0.0.2:9080
+productpage:app: +12ms      handling request id-1
+`))
+	})
+})
@@ -0,0 +1,554 @@
+package logs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/fatih/color"
+	"github.com/moby/term"
+)
+
+const (
+	// the number of lines the terminal ui keeps. older lines are dropped.
+	tuiMaxLines = 50000
+	tuiRefresh  = 50 * time.Millisecond
+
+	ansiReset   = "\x1b[0m"
+	ansiDim     = "\x1b[2m"
+	ansiReverse = "\x1b[7m"
+
+	tuiHelp = "q quit  space pause  ↑↓ pgup pgdn scroll  / search  n N older/newer match  f filter  1-9 0 toggle pods  a all pods"
+)
+
+type tuiLine struct {
+	seq    uint64
+	source *tuiSource
+	text   string
+	// status lines are messages about the stream, not log lines. they are not filtered.
+	status bool
+}
+
+type tuiSource struct {
+	name   string
+	color  *color.Color
+	hidden bool
+}
+
+// tui is an interactive terminal view of the log lines of all the pods, that can be paused,
+// searched, filtered, and where pods can be toggled on and off.
+type tui struct {
+	quit func()
+
+	lock sync.Mutex
+	// the lines, oldest first.
+	lines   []tuiLine
+	seq     uint64
+	sources []*tuiSource
+	byName  map[string]*tuiSource
+	// when paused, the view shows the lines up to pausedAt, so it doesn't move as new lines arrive.
+	paused   bool
+	pausedAt uint64
+	// the number of shown lines the view is scrolled up from the last one.
+	scroll int
+	// the number of rows of log lines in the last render.
+	rows   int
+	filter *regexp.Regexp
+	search *regexp.Regexp
+	// the prompt being edited, e.g. "search". empty when not editing.
+	prompt string
+	input  []rune
+	// shown in the status bar until the next key, e.g. an invalid regular expression.
+	message string
+	dirty   bool
+
+	// the terminal.
+	out     io.Writer
+	restore func()
+	done    chan struct{}
+	stopped chan struct{}
+	// closed when readKeys returns.
+	keysStopped chan struct{}
+}
+
+func newTUI(quit func()) *tui {
+	return &tui{quit: quit, byName: map[string]*tuiSource{}, rows: 1, dirty: true}
+}
+
+// startTUI takes over the terminal until close is called. quit is called when the user quits.
+func startTUI(in io.Reader, out io.Writer, quit func()) (*tui, error) {
+	inFd, inTerm := term.GetFdInfo(in)
+	outFd, outTerm := term.GetFdInfo(out)
+	if !inTerm || !outTerm {
+		return nil, fmt.Errorf("the terminal ui requires an interactive terminal")
+	}
+	state, err := term.MakeRaw(inFd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set the terminal to raw mode: %w", err)
+	}
+	t := newTUI(quit)
+	t.out = out
+	t.restore = func() { term.RestoreTerminal(inFd, state) }
+	t.done = make(chan struct{})
+	t.stopped = make(chan struct{})
+	t.keysStopped = make(chan struct{})
+	// switch to the alternate screen, so the shell's screen is restored when we are done.
+	io.WriteString(out, "\x1b[?1049h\x1b[?25l")
+	go t.readKeys(in, inFd)
+	go t.renderLoop(outFd)
+	return t, nil
+}
+
+// close restores the terminal.
+func (t *tui) close() {
+	close(t.done)
+	<-t.stopped
+	// don't let readKeys take the input of whatever reads the terminal after us.
+	if pollKeys {
+		<-t.keysStopped
+	}
+	io.WriteString(t.out, "\x1b[?25h\x1b[?1049l")
+	t.restore()
+}
+
+func (t *tui) renderLoop(fd uintptr) {
+	defer close(t.stopped)
+	ticker := time.NewTicker(tuiRefresh)
+	defer ticker.Stop()
+	var width, height int
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-ticker.C:
+		}
+		// polling the size works on all platforms, unlike SIGWINCH.
+		resized := false
+		if ws, err := term.GetWinsize(fd); err == nil && (int(ws.Width) != width || int(ws.Height) != height) {
+			width, height, resized = int(ws.Width), int(ws.Height), true
+		}
+		t.lock.Lock()
+		if (!t.dirty && !resized) || width == 0 || height == 0 {
+			t.lock.Unlock()
+			continue
+		}
+		frame := t.render(width, height)
+		t.dirty = false
+		t.lock.Unlock()
+		t.out.Write(frame)
+	}
+}
+
+// readKeys handles the keys read from the terminal until the ui is closed.
+func (t *tui) readKeys(in io.Reader, fd uintptr) {
+	defer close(t.keysStopped)
+	buf := make([]byte, 256)
+	for t.waitForKeys(fd) {
+		n, err := in.Read(buf)
+		select {
+		case <-t.done:
+			return
+		default:
+		}
+		for _, key := range parseKeys(buf[:n]) {
+			t.handleKey(key)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// source returns the source with this name, adding it if it is new.
+func (t *tui) source(name string, c *color.Color) *tuiSource {
+	s, ok := t.byName[name]
+	if !ok {
+		s = &tuiSource{name: name, color: c}
+		t.byName[name] = s
+		t.sources = append(t.sources, s)
+	}
+	return s
+}
+
+// add adds a line of a source.
+func (t *tui) add(name string, c *color.Color, text string, status bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.seq++
+	t.lines = append(t.lines, tuiLine{seq: t.seq, source: t.source(name, c), text: text, status: status})
+	if len(t.lines) > tuiMaxLines {
+		t.lines = t.lines[len(t.lines)-tuiMaxLines:]
+	}
+	t.dirty = true
+}
+
+// writer returns a writer that adds the lines written to it as lines of a source, e.g. for the
+// output of the user command.
+func (t *tui) writer(name string, c *color.Color) io.Writer {
+	return &tuiWriter{t: t, name: name, color: c}
+}
+
+type tuiWriter struct {
+	t       *tui
+	name    string
+	color   *color.Color
+	pending []byte
+}
+
+func (w *tuiWriter) Write(b []byte) (int, error) {
+	w.pending = append(w.pending, b...)
+	for {
+		index := bytes.IndexByte(w.pending, '\n')
+		if index < 0 {
+			return len(b), nil
+		}
+		w.t.add(w.name, w.color, string(w.pending[:index]), false)
+		w.pending = w.pending[index+1:]
+	}
+}
+
+// shown returns the lines in the view: up to where it was paused, of the sources that are not
+// hidden, and that match the filter.
+func (t *tui) shown() []*tuiLine {
+	var lines []*tuiLine
+	for i := range t.lines {
+		l := &t.lines[i]
+		if t.paused && l.seq > t.pausedAt {
+			break
+		}
+		if l.source.hidden || (t.filter != nil && !l.status && !t.filter.MatchString(l.text)) {
+			continue
+		}
+		lines = append(lines, l)
+	}
+	return lines
+}
+
+// render returns a frame of the view. the lock must be held.
+func (t *tui) render(width, height int) []byte {
+	t.rows = height - 2
+	if t.rows < 1 {
+		t.rows = 1
+	}
+	lines := t.shown()
+	if maxScroll := len(lines) - t.rows; t.scroll > maxScroll {
+		t.scroll = maxScroll
+	}
+	if t.scroll < 0 {
+		t.scroll = 0
+	}
+	end := len(lines) - t.scroll
+	start := end - t.rows
+	if start < 0 {
+		start = 0
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("\x1b[H\x1b[2K")
+	t.renderHeader(&buf, width)
+	for i := 0; i < t.rows; i++ {
+		buf.WriteString("\r\n\x1b[2K")
+		if start+i < end {
+			t.renderLine(&buf, lines[start+i], width)
+		}
+	}
+	buf.WriteString("\r\n\x1b[2K")
+	buf.WriteString(ansiReverse + pad(truncate(t.statusText(), width), width) + ansiReset)
+	return buf.Bytes()
+}
+
+// renderHeader lists the sources, with the keys that toggle them.
+func (t *tui) renderHeader(buf *bytes.Buffer, width int) {
+	used := 0
+	for i, s := range t.sources {
+		label := s.name
+		if i < 10 {
+			label = fmt.Sprintf("%d:%s", (i+1)%10, s.name)
+		}
+		if used != 0 {
+			label = " " + label
+		}
+		n := utf8.RuneCountInString(label)
+		if used+n > width {
+			return
+		}
+		used += n
+		if s.hidden {
+			buf.WriteString(ansiDim + label + ansiReset)
+		} else {
+			buf.WriteString(s.color.Sprint(label))
+		}
+	}
+}
+
+func (t *tui) renderLine(buf *bytes.Buffer, l *tuiLine, width int) {
+	prefix := truncate(l.source.name+":", width)
+	buf.WriteString(l.source.color.Sprint(prefix))
+	text := truncate(" "+sanitize(l.text), width-utf8.RuneCountInString(prefix))
+	if l.status {
+		buf.WriteString(ansiDim + text + ansiReset)
+		return
+	}
+	if t.search == nil {
+		buf.WriteString(text)
+		return
+	}
+	end := 0
+	for _, m := range t.search.FindAllStringIndex(text, -1) {
+		if m[0] == m[1] {
+			continue
+		}
+		buf.WriteString(text[end:m[0]])
+		buf.WriteString(ansiReverse + text[m[0]:m[1]] + ansiReset)
+		end = m[1]
+	}
+	buf.WriteString(text[end:])
+}
+
+func (t *tui) statusText() string {
+	if t.prompt != "" {
+		return t.prompt + ": " + string(t.input)
+	}
+	parts := []string{"FOLLOWING"}
+	if t.paused {
+		parts[0] = fmt.Sprintf("PAUSED (%d new lines)", t.seq-t.pausedAt)
+	}
+	if t.filter != nil {
+		parts = append(parts, "filter: "+t.filter.String())
+	}
+	if t.search != nil {
+		parts = append(parts, "search: "+t.search.String())
+	}
+	if t.message != "" {
+		parts = append(parts, t.message)
+	}
+	return strings.Join(append(parts, tuiHelp), " | ")
+}
+
+func (t *tui) handleKey(key string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.dirty = true
+	t.message = ""
+	if key == "ctrl-c" {
+		t.quit()
+		return
+	}
+	if t.prompt != "" {
+		t.handlePromptKey(key)
+		return
+	}
+	switch key {
+	case "q":
+		t.quit()
+	case " ", "p":
+		if t.paused {
+			t.follow()
+		} else {
+			t.pause()
+		}
+	case "up", "k":
+		t.scrollBy(1)
+	case "down", "j":
+		t.scrollBy(-1)
+	case "pgup":
+		t.scrollBy(t.rows)
+	case "pgdn":
+		t.scrollBy(-t.rows)
+	case "home", "g":
+		t.scrollBy(len(t.lines))
+	case "end", "G":
+		t.follow()
+	case "/", "f":
+		t.prompt, t.input = "search", nil
+		if key == "f" {
+			t.prompt = "filter"
+		}
+	case "n":
+		t.nextMatch(true)
+	case "N":
+		t.nextMatch(false)
+	case "esc":
+		t.search = nil
+	case "a":
+		for _, s := range t.sources {
+			s.hidden = false
+		}
+	case "1", "2", "3", "4", "5", "6", "7", "8", "9", "0":
+		i := (int(key[0]-'0') + 9) % 10
+		if i < len(t.sources) {
+			t.sources[i].hidden = !t.sources[i].hidden
+		}
+	}
+}
+
+func (t *tui) handlePromptKey(key string) {
+	switch key {
+	case "esc":
+		t.prompt = ""
+	case "backspace":
+		if len(t.input) != 0 {
+			t.input = t.input[:len(t.input)-1]
+		}
+	case "enter":
+		var re *regexp.Regexp
+		if len(t.input) != 0 {
+			var err error
+			if re, err = regexp.Compile(string(t.input)); err != nil {
+				t.message = fmt.Sprintf("invalid %s: %v", t.prompt, err)
+				t.prompt = ""
+				return
+			}
+		}
+		if t.prompt == "filter" {
+			t.filter = re
+		} else {
+			t.search = re
+			if re != nil {
+				t.nextMatch(true)
+			}
+		}
+		t.prompt = ""
+	default:
+		if utf8.RuneCountInString(key) == 1 {
+			t.input = append(t.input, []rune(key)...)
+		}
+	}
+}
+
+func (t *tui) pause() {
+	if !t.paused {
+		t.paused, t.pausedAt = true, t.seq
+	}
+}
+
+func (t *tui) follow() {
+	t.paused, t.scroll = false, 0
+}
+
+// scrollBy scrolls the view up by n lines, or down if n is negative. scrolling pauses the view, so
+// it doesn't move under the user.
+func (t *tui) scrollBy(n int) {
+	if n > 0 {
+		t.pause()
+	}
+	t.scroll += n
+	if t.scroll < 0 {
+		t.scroll = 0
+	}
+}
+
+// nextMatch scrolls to the next line that matches the search, older or newer than the last line
+// in the view.
+func (t *tui) nextMatch(older bool) {
+	if t.search == nil {
+		t.message = "no search"
+		return
+	}
+	t.pause()
+	lines := t.shown()
+	last := len(lines) - 1 - t.scroll
+	step := 1
+	if older {
+		step = -1
+	}
+	for i := last + step; i >= 0 && i < len(lines); i += step {
+		if !lines[i].status && t.search.MatchString(lines[i].text) {
+			t.scroll = len(lines) - 1 - i
+			return
+		}
+	}
+	t.message = "no more matches"
+}
+
+var escapeKeys = map[string]string{
+	"[A": "up", "[B": "down", "[5~": "pgup", "[6~": "pgdn",
+	"[H": "home", "[F": "end", "[1~": "home", "[4~": "end",
+	"OA": "up", "OB": "down", "OH": "home", "OF": "end",
+}
+
+// parseKeys splits terminal input in raw mode to keys: printable characters as is, or names such
+// as "up" or "enter".
+func parseKeys(b []byte) []string {
+	var keys []string
+	for len(b) != 0 {
+		c := b[0]
+		switch {
+		case c == 0x1b:
+			key, n := parseEscape(b[1:])
+			if key != "" {
+				keys = append(keys, key)
+			}
+			b = b[1+n:]
+			continue
+		case c == 3:
+			keys = append(keys, "ctrl-c")
+		case c == '\r' || c == '\n':
+			keys = append(keys, "enter")
+		case c == 0x7f || c == 8:
+			keys = append(keys, "backspace")
+		case c >= 0x20:
+			r, size := utf8.DecodeRune(b)
+			keys = append(keys, string(r))
+			b = b[size:]
+			continue
+		}
+		b = b[1:]
+	}
+	return keys
+}
+
+// parseEscape parses the rest of an escape sequence. returns the key, or "esc" for the escape key,
+// and the length of the sequence.
+func parseEscape(b []byte) (string, int) {
+	for seq, key := range escapeKeys {
+		if bytes.HasPrefix(b, []byte(seq)) {
+			return key, len(seq)
+		}
+	}
+	if len(b) == 0 || b[0] != '[' {
+		return "esc", 0
+	}
+	// skip other control sequences, up to their final byte.
+	for i := 1; i < len(b); i++ {
+		if b[i] >= 0x40 && b[i] <= 0x7e {
+			return "", i + 1
+		}
+	}
+	return "", len(b)
+}
+
+// sanitize replaces control characters, so lines can't move the cursor.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\t' {
+			return ' '
+		}
+		if r < 0x20 || r == 0x7f {
+			return '?'
+		}
+		return r
+	}, s)
+}
+
+func truncate(s string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	return string([]rune(s)[:width])
+}
+
+func pad(s string, width int) string {
+	if n := utf8.RuneCountInString(s); n < width {
+		return s + strings.Repeat(" ", width-n)
+	}
+	return s
+}
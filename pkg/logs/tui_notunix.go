@@ -0,0 +1,17 @@
+//go:build !linux && !darwin
+
+package logs
+
+// pollKeys is true when readKeys stops as soon as the ui is closed.
+const pollKeys = false
+
+// waitForKeys can't wait for the terminal here, so readKeys blocks in Read. After the ui is closed,
+// it returns on the next key and drops it. This only happens once, when the command is done.
+func (t *tui) waitForKeys(fd uintptr) bool {
+	select {
+	case <-t.done:
+		return false
+	default:
+		return true
+	}
+}
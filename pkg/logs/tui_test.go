@@ -0,0 +1,105 @@
+package logs
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/fatih/color"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("tui", func() {
+	var (
+		view *tui
+		quit bool
+	)
+	BeforeEach(func() {
+		quit = false
+		view = newTUI(func() { quit = true })
+		for _, name := range []string{"pod1", "pod2"} {
+			for _, line := range []string{"hello", "error", "bye"} {
+				view.add(name, color.New(color.FgRed), name+" "+line, false)
+			}
+		}
+		view.render(80, 10)
+	})
+	texts := func() []string {
+		var texts []string
+		for _, l := range view.shown() {
+			texts = append(texts, l.text)
+		}
+		return texts
+	}
+	keys := func(input string) {
+		for _, key := range parseKeys([]byte(input)) {
+			view.handleKey(key)
+		}
+	}
+
+	It("should parse keys", func() {
+		Expect(parseKeys([]byte("a\x1b[A\x1b[6~\r\x7f\x1b\x03\x1b[2J"))).To(Equal([]string{"a", "up", "pgdn", "enter", "backspace", "esc", "ctrl-c"}))
+	})
+
+	It("should quit", func() {
+		keys("q")
+		Expect(quit).To(BeTrue())
+	})
+
+	It("should not show new lines when paused", func() {
+		keys(" ")
+		view.add("pod1", color.New(color.FgRed), "new", false)
+		Expect(texts()).To(HaveLen(6))
+		Expect(view.statusText()).To(HavePrefix("PAUSED (1 new lines)"))
+		keys(" ")
+		Expect(texts()).To(HaveLen(7))
+	})
+
+	It("should toggle pods", func() {
+		keys("1")
+		Expect(texts()).To(Equal([]string{"pod2 hello", "pod2 error", "pod2 bye"}))
+		keys("a")
+		Expect(texts()).To(HaveLen(6))
+	})
+
+	It("should filter lines", func() {
+		keys("ferr\r")
+		Expect(texts()).To(Equal([]string{"pod1 error", "pod2 error"}))
+		keys("f\r")
+		Expect(texts()).To(HaveLen(6))
+	})
+
+	It("should report invalid patterns", func() {
+		keys("f(\r")
+		Expect(view.filter).To(BeNil())
+		Expect(view.statusText()).To(ContainSubstring("invalid filter"))
+	})
+
+	It("should scroll to the matches of the search", func() {
+		keys("/error\r")
+		Expect(view.search).To(Equal(regexp.MustCompile("error")))
+		// pod2 error is the second to last line.
+		Expect(view.scroll).To(Equal(1))
+		keys("n")
+		Expect(view.scroll).To(Equal(4))
+		keys("n")
+		Expect(view.statusText()).To(ContainSubstring("no more matches"))
+		keys("N")
+		Expect(view.scroll).To(Equal(1))
+	})
+
+	It("should render the last lines that fit", func() {
+		frame := string(view.render(80, 4))
+		Expect(frame).NotTo(ContainSubstring("pod1 bye"))
+		Expect(frame).To(ContainSubstring("pod2 error"))
+		Expect(frame).To(ContainSubstring("pod2 bye"))
+		Expect(strings.Count(frame, "\r\n")).To(Equal(3))
+	})
+
+	It("should add the lines written to its writers", func() {
+		w := view.writer("command", color.New(color.FgRed))
+		w.Write([]byte("one\ntw"))
+		w.Write([]byte("o\n"))
+		Expect(texts()[6:]).To(Equal([]string{"one", "two"}))
+	})
+})
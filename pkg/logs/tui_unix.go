@@ -0,0 +1,33 @@
+//go:build linux || darwin
+
+package logs
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// pollKeys is true when readKeys stops as soon as the ui is closed.
+const pollKeys = true
+
+// waitForKeys waits until there are keys to read from the terminal, and returns false if the ui was
+// closed first. Polling, rather than blocking in Read, lets readKeys stop when the ui is closed.
+func (t *tui) waitForKeys(fd uintptr) bool {
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+	for {
+		select {
+		case <-t.done:
+			return false
+		default:
+		}
+		n, err := unix.Poll(fds, int(tuiRefresh.Milliseconds()))
+		if err != nil && !errors.Is(err, unix.EINTR) {
+			// let Read report the error.
+			return true
+		}
+		if n > 0 {
+			return true
+		}
+	}
+}
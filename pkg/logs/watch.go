@@ -0,0 +1,171 @@
+package logs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// PodSelector selects the pods to follow in watch mode.
+type PodSelector struct {
+	// Namespace of the pods. empty for the namespace of the printer. when set, the namespace is shown
+	// in the names of the pods.
+	Namespace string
+	// AllNamespaces selects pods in all namespaces, and shows their namespace.
+	AllNamespaces bool
+	LabelSelector string
+	FieldSelector string
+	// may be empty, for the first container of the pod. may also be a glob, see SelectContainers.
+	ContainerName string
+	// AllContainers follows all the containers of the pods, including init and ephemeral containers.
+	AllContainers bool
+}
+
+// WatchLogs follows the logs of the pods matching the selectors. Unlike PrintLogs, it attaches to
+// pods and containers as they start, and re-attaches to containers after they restart. The printer
+// must not be in Snapshot or Previous mode.
+func (m *MultiLogPrinter) WatchLogs(ctx context.Context, pods typedcorev1.PodsGetter, selectors []PodSelector) error {
+	return m.run(ctx, true, func(p *logPipeline) error {
+		w := &podWatcher{
+			pipeline:  p,
+			pods:      pods,
+			namespace: m.Namespace,
+			start:     time.Now(),
+			following: map[string]string{},
+		}
+		for _, selector := range selectors {
+			if err := w.watch(selector); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+type podWatcher struct {
+	pipeline *logPipeline
+	pods     typedcorev1.PodsGetter
+	// the namespace of selectors that don't have one.
+	namespace string
+	// containers that started before this time are followed like in PrintLogs, from their current end
+	// unless the printer asks for older lines.
+	start time.Time
+
+	lock sync.Mutex
+	// the id of the container we follow for each namespace, pod and container name, so we know when
+	// it restarted.
+	following map[string]string
+}
+
+func (w *podWatcher) watch(selector PodSelector) error {
+	ctx := w.pipeline.ctx
+	namespace := selector.Namespace
+	if selector.AllNamespaces {
+		namespace = metav1.NamespaceAll
+	} else if namespace == "" {
+		namespace = w.namespace
+	}
+	podclient := w.pods.Pods(namespace)
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = selector.LabelSelector
+			options.FieldSelector = selector.FieldSelector
+			return podclient.List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = selector.LabelSelector
+			options.FieldSelector = selector.FieldSelector
+			return podclient.Watch(ctx, options)
+		},
+	}
+	informer := cache.NewSharedIndexInformer(lw, &corev1.Pod{}, 0, cache.Indexers{})
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			w.attachAll(obj.(*corev1.Pod), selector)
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			w.attachAll(obj.(*corev1.Pod), selector)
+		},
+	})
+	go informer.Run(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return fmt.Errorf("failed to list pods: %w", ctx.Err())
+	}
+	return nil
+}
+
+// attachAll follows the logs of the selected containers of the pod.
+func (w *podWatcher) attachAll(pod *corev1.Pod, selector PodSelector) {
+	// containers may be added to the pod later, e.g. ephemeral containers, so they are selected on
+	// every update.
+	for _, name := range SelectContainers(pod, selector.ContainerName, selector.AllContainers) {
+		w.attach(pod, name, selector.Namespace != "" || selector.AllNamespaces)
+	}
+}
+
+// attach follows the logs of the container, unless we already follow this instance of it.
+func (w *podWatcher) attach(pod *corev1.Pod, containerName string, showNamespace bool) {
+	if pod.DeletionTimestamp != nil || len(pod.Spec.Containers) == 0 {
+		return
+	}
+	name := containerName
+	if name == "" {
+		name = pod.Spec.Containers[0].Name
+	}
+	status := containerStatus(pod, name)
+	if status == nil || status.ContainerID == "" {
+		return
+	}
+	// containers that ran and exited between updates, e.g. short init containers, are printed too.
+	exited := status.State.Terminated != nil && !status.State.Terminated.StartedAt.Time.Before(w.start)
+	if status.State.Running == nil && !exited {
+		return
+	}
+
+	source := PodAndContainerName{Namespace: pod.Namespace, PodName: pod.Name, ContainerName: containerName}
+	key := source.String()
+	if !showNamespace {
+		source.Namespace = ""
+	}
+	podName := source.String()
+	containerID := status.ContainerID
+	w.lock.Lock()
+	if w.following[key] == containerID {
+		w.lock.Unlock()
+		return
+	}
+	w.following[key] = containerID
+	w.lock.Unlock()
+
+	// show new containers from their first line.
+	opts := &corev1.PodLogOptions{
+		Container:  name,
+		Follow:     true,
+		Timestamps: true,
+	}
+	if status.State.Running != nil && status.State.Running.StartedAt.Time.Before(w.start) {
+		opts = w.pipeline.logOptions(name)
+	}
+	w.pipeline.follow(&streamRequest{
+		podName:   podName,
+		source:    PodAndContainerName{Namespace: source.Namespace, PodName: pod.Name, ContainerName: name},
+		podclient: w.pods.Pods(pod.Namespace),
+		opts:      opts,
+		onFail: func() {
+			// try again on the next update of the pod.
+			w.lock.Lock()
+			defer w.lock.Unlock()
+			if w.following[key] == containerID {
+				delete(w.following, key)
+			}
+		},
+	})
+}
@@ -0,0 +1,65 @@
+package logs_test
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/solo-io/kdiag/pkg/logs"
+)
+
+func runningPod(name, containerID string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{"app": "test"}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+			Name:        "app",
+			ContainerID: containerID,
+			State:       corev1.ContainerState{Running: &corev1.ContainerStateRunning{StartedAt: metav1.Now()}},
+		}}},
+	}
+}
+
+var _ = Describe("WatchLogs", func() {
+	It("should follow new pods and restarted containers", func() {
+		clientset := fake.NewSimpleClientset(runningPod("pod1", "id1"))
+		podclient := clientset.CoreV1().Pods("default")
+		out := &bytes.Buffer{}
+		printer := logs.MultiLogPrinter{Out: out, ErrOut: out, Namespace: "default"}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		go func() {
+			defer GinkgoRecover()
+			time.Sleep(500 * time.Millisecond)
+			_, err := podclient.Create(ctx, runningPod("pod2", "id2"), metav1.CreateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			time.Sleep(500 * time.Millisecond)
+			// the container restarted. updates that don't change the container should be ignored.
+			for i := 0; i < 2; i++ {
+				_, err = podclient.Update(ctx, runningPod("pod1", "id3"), metav1.UpdateOptions{})
+				Expect(err).NotTo(HaveOccurred())
+			}
+		}()
+
+		err := printer.WatchLogs(ctx, clientset.CoreV1(), []logs.PodSelector{{LabelSelector: "app=test"}})
+		Expect(err).NotTo(HaveOccurred())
+		// the fake clientset returns "fake logs" for every stream.
+		Expect(out.String()).To(Equal(`following pod pod1
+pod1: fake logs
+pod pod1 is done
+following pod pod2
+pod2: fake logs
+pod pod2 is done
+following pod pod1
+pod1: fake logs
+pod pod1 is done
+`))
+	})
+})
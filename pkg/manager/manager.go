@@ -15,15 +15,34 @@ import (
 	"github.com/solo-io/kdiag/pkg/srv"
 	"google.golang.org/grpc"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
 )
 
 type Manager interface {
-	GetListeneningPorts(ctx context.Context) ([]uint16, error)
+	GetListeneningPorts(ctx context.Context, filter ListenFilter) ([]uint16, error)
+	Processes(ctx context.Context) ([]*pb.PsResponse_ProcessInfo, error)
 	RedirectIncomingTraffic(ctx context.Context, podPort, localPort uint16) error
 	RedirectOutgoingTraffic(ctx context.Context, podPort, localPort uint16) error
+	// RedirectLocalTraffic redirects the outgoing connections to localhost, e.g. from the app to the
+	// admin port of its sidecar.
+	RedirectLocalTraffic(ctx context.Context, podPort, localPort uint16) error
+	Close() error
+}
+
+// ListenFilter selects the ports returned by GetListeneningPorts.
+type ListenFilter struct {
+	// IncludeLoopback includes the ports that listen on loopback addresses, that can only be reached
+	// from inside the pod.
+	IncludeLoopback bool
+	// Pid, when not zero, only includes the ports of this process.
+	Pid uint64
+	// ProcessName, when set, only includes the ports of the processes with this name.
+	ProcessName string
+	// ExcludePorts are never included.
+	ExcludePorts []uint16
 }
 type manager struct {
 	RESTConfig   *rest.Config
@@ -99,37 +118,67 @@ func (m *manager) Close() error {
 	return nil
 }
 
-func (m *manager) GetListeneningPorts(ctx context.Context) ([]uint16, error) {
+func (m *manager) Processes(ctx context.Context) ([]*pb.PsResponse_ProcessInfo, error) {
 	resp, err := m.client.Ps(ctx, &pb.PsRequest{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get processes: %w", err)
 	}
-	ports := lo.FlatMap(resp.Processes, func(t *pb.PsResponse_ProcessInfo, _ int) []uint16 {
+	return resp.Processes, nil
+}
+
+func (m *manager) GetListeneningPorts(ctx context.Context, filter ListenFilter) ([]uint16, error) {
+	processes, err := m.Processes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// the container ids of the processes are resolved with the statuses of the pod.
+	podObj, err := m.clientset.CoreV1().Pods(m.podnamespace).Get(ctx, m.podname, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pod: %w", err)
+	}
+	return listeningPorts(podObj, processes, filter), nil
+}
+
+// listeningPorts returns the ports that the processes of the pod listen on, selected by the filter.
+// The ports of the managers are never included: they are the grpc port, and the listeners of the
+// redirects, that redirecting would break.
+func listeningPorts(podObj *corev1.Pod, processes []*pb.PsResponse_ProcessInfo, filter ListenFilter) []uint16 {
+	processes = lo.Filter(processes, func(p *pb.PsResponse_ProcessInfo, _ int) bool {
+		if isManagerContainer(ContainerNameForID(podObj, p.ContainerId)) {
+			return false
+		}
+		return (filter.Pid == 0 || p.Pid == filter.Pid) && (filter.ProcessName == "" || p.Name == filter.ProcessName)
+	})
+	ports := lo.FlatMap(processes, func(t *pb.PsResponse_ProcessInfo, _ int) []uint16 {
 		return lo.Map(t.ListenAddresses, func(a *pb.Address, _ int) uint16 {
-			// exclude local host address, as they cannot be reached from outside
-			// should we make this an option?
 			listenaAddr, err := netip.ParseAddr(a.Ip)
 			if err != nil {
 				return 0
 			}
-			if listenaAddr.IsLoopback() {
+			// exclude local host address by default, as they cannot be reached from outside
+			if listenaAddr.IsLoopback() && !filter.IncludeLoopback {
 				return 0
 			}
 			return uint16(a.Port)
 		})
 	})
 
-	return lo.Reject(ports, func(v uint16, _ int) bool {
-		return v == 0
-	}), nil
+	// a port may be listened on by more than one address, e.g. ipv4 and ipv6.
+	return lo.Uniq(lo.Reject(ports, func(v uint16, _ int) bool {
+		return v == 0 || lo.Contains(filter.ExcludePorts, v)
+	}))
 }
 
 func (m *manager) RedirectIncomingTraffic(ctx context.Context, podPort, localPort uint16) error {
-	return srv.Redirect(ctx, m.client, false, podPort, localPort, m.newPortForward)
+	return srv.Redirect(ctx, m.client, false, false, podPort, localPort, m.newPortForward)
 }
 
 func (m *manager) RedirectOutgoingTraffic(ctx context.Context, podPort, localPort uint16) error {
-	return srv.Redirect(ctx, m.client, true, podPort, localPort, m.newPortForward)
+	return srv.Redirect(ctx, m.client, true, false, podPort, localPort, m.newPortForward)
+}
+
+func (m *manager) RedirectLocalTraffic(ctx context.Context, podPort, localPort uint16) error {
+	return srv.Redirect(ctx, m.client, true, true, podPort, localPort, m.newPortForward)
 }
 
 func (m *manager) newPortForward(ctx context.Context, port uint16) (*frwrd.PortForward, error) {
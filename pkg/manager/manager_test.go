@@ -0,0 +1,80 @@
+package manager
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	pb "github.com/solo-io/kdiag/pkg/api/kdiag"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var _ = Describe("listeningPorts", func() {
+	podObj := &corev1.Pod{
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "app", ContainerID: "containerd://app-id"},
+				{Name: "istio-proxy", ContainerID: "containerd://proxy-id"},
+			},
+			EphemeralContainerStatuses: []corev1.ContainerStatus{
+				{Name: "dbg-tools-1234", ContainerID: "containerd://manager-id"},
+				{Name: "dbg-tools-1234-5678", ContainerID: "containerd://other-manager-id"},
+			},
+		},
+	}
+	processes := []*pb.PsResponse_ProcessInfo{
+		{
+			Pid:         1,
+			Name:        "app",
+			ContainerId: "app-id",
+			ListenAddresses: []*pb.Address{
+				{Ip: "0.0.0.0", Port: 8080},
+				{Ip: "::", Port: 8080},
+				{Ip: "127.0.0.1", Port: 9090},
+			},
+		},
+		{
+			Pid:         2,
+			Name:        "envoy",
+			ContainerId: "proxy-id",
+			ListenAddresses: []*pb.Address{
+				{Ip: "0.0.0.0", Port: 15001},
+				{Ip: "::1", Port: 15000},
+				{Ip: "not-an-ip", Port: 1234},
+			},
+		},
+		// the grpc port and the redirect listeners of the managers.
+		{
+			Pid:         3,
+			Name:        "manager",
+			ContainerId: "manager-id",
+			ListenAddresses: []*pb.Address{
+				{Ip: "::", Port: 40000},
+				{Ip: "127.0.0.1", Port: 40001},
+			},
+		},
+		{
+			Pid:         4,
+			Name:        "manager",
+			ContainerId: "other-manager-id",
+			ListenAddresses: []*pb.Address{
+				{Ip: "::", Port: 40002},
+			},
+		},
+	}
+
+	DescribeTable("should select the ports of the filter",
+		func(filter ListenFilter, expected []uint16) {
+			Expect(listeningPorts(podObj, processes, filter)).To(ConsistOf(expected))
+		},
+		Entry("default, without loopback and with v4/v6 deduplicated", ListenFilter{}, []uint16{8080, 15001}),
+		Entry("loopback", ListenFilter{IncludeLoopback: true}, []uint16{8080, 9090, 15000, 15001}),
+		Entry("pid", ListenFilter{Pid: 2}, []uint16{15001}),
+		Entry("pid with loopback", ListenFilter{Pid: 2, IncludeLoopback: true}, []uint16{15000, 15001}),
+		Entry("process name", ListenFilter{ProcessName: "app", IncludeLoopback: true}, []uint16{8080, 9090}),
+		Entry("pid and other process name", ListenFilter{Pid: 1, ProcessName: "envoy"}, []uint16{}),
+		Entry("unknown pid", ListenFilter{Pid: 3}, []uint16{}),
+		Entry("manager pid", ListenFilter{Pid: 3, IncludeLoopback: true}, []uint16{}),
+		Entry("manager process name", ListenFilter{ProcessName: "manager", IncludeLoopback: true}, []uint16{}),
+		Entry("exclude ports", ListenFilter{IncludeLoopback: true, ExcludePorts: []uint16{8080, 15000}}, []uint16{9090, 15001}),
+	)
+})
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"hash/fnv"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/samber/lo"
@@ -31,45 +32,61 @@ var (
 	portRegexp = regexp.MustCompile(`Listening on .+:(\d+)`)
 )
 
-// Create or connect to an ephemeral manager container in a pod
-func (e *EmephemeralContainerManager) EnsurePodManaged(ctx context.Context, ns, pod, dbgimg, target string, pullPolicy corev1.PullPolicy) (*corev1.Pod, error) {
+// managerContainerPrefix is the prefix of the names of the manager containers, of all versions.
+const managerContainerPrefix = "dbg-tools-"
+
+// Create or connect to an ephemeral manager container in a pod. Returns the pod and the name of the
+// manager container that targets the requested container.
+func (e *EmephemeralContainerManager) EnsurePodManaged(ctx context.Context, ns, pod, dbgimg, target string, pullPolicy corev1.PullPolicy) (*corev1.Pod, string, error) {
 
 	// name prefix is "dbg-tools-versionhash"
 
 	podclient := e.podGetter.Pods(ns)
 	podObj, err := podclient.Get(ctx, pod, metav1.GetOptions{})
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+	if target == "" {
+		target = podObj.Spec.Containers[0].Name
 	}
 	name := e.ContainerName()
 
-	_, found := lo.Find(podObj.Spec.EphemeralContainers, func(t corev1.EphemeralContainer) bool {
+	existing, found := lo.Find(podObj.Spec.EphemeralContainers, func(t corev1.EphemeralContainer) bool {
 		if t.Name == name {
 			return true
 		}
 		return false
 	})
+	// ephemeral containers can't be changed once created. if the existing manager targets
+	// a different container, use (or create) a manager dedicated to the requested target.
+	if found && existing.TargetContainerName != target {
+		name = e.containerNameForTarget(target)
+		_, found = lo.Find(podObj.Spec.EphemeralContainers, func(t corev1.EphemeralContainer) bool {
+			return t.Name == name
+		})
+	}
 	if !found {
 		podObj, err = e.createContainer(ctx, name, dbgimg, target, pullPolicy, podObj)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 	}
-	err = e.waitForReady(ctx, podclient, podObj)
+	// the status of the pod we have may predate the manager, so use the one of the running manager.
+	podObj, err = e.waitForReady(ctx, podclient, podObj, name)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	return podObj, nil
+	return podObj, name, nil
 }
 
-func (e *EmephemeralContainerManager) waitForReady(ctx context.Context, podclient typedcorev1.PodInterface, podObj *corev1.Pod) error {
+// waitForReady waits for the manager container to run, and returns the pod with its status.
+func (e *EmephemeralContainerManager) waitForReady(ctx context.Context, podclient typedcorev1.PodInterface, podObj *corev1.Pod, name string) (*corev1.Pod, error) {
 	timeout := time.After(5 * time.Minute)
-	name := e.ContainerName()
 	for {
 		updatedPod, err := podclient.Get(ctx, podObj.Name, metav1.GetOptions{})
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		container, found := lo.Find(updatedPod.Status.EphemeralContainerStatuses, func(t corev1.ContainerStatus) bool {
@@ -80,15 +97,15 @@ func (e *EmephemeralContainerManager) waitForReady(ctx context.Context, podclien
 		})
 		if found {
 			if container.State.Running != nil {
-				return nil
+				return updatedPod, nil
 			}
 		}
 
 		select {
 		case <-ctx.Done():
-			return nil
+			return nil, ctx.Err()
 		case <-timeout:
-			return fmt.Errorf("timeout waiting for pod to be ready")
+			return nil, fmt.Errorf("timeout waiting for pod to be ready")
 		case <-time.After(1 * time.Second):
 		}
 	}
@@ -99,13 +116,46 @@ func (e *EmephemeralContainerManager) ContainerName() string {
 	h := fnv.New32()
 	h.Write([]byte(version.Version))
 
-	name := fmt.Sprintf("dbg-tools-%x", h.Sum32())
+	name := fmt.Sprintf("%s%x", managerContainerPrefix, h.Sum32())
 	return name
 }
 
-func (e *EmephemeralContainerManager) ManagerPort(ctx context.Context, podclient typedcorev1.PodInterface, podObj *corev1.Pod) (uint16, error) {
+// containerNameForTarget returns the name of the manager container used when the pod already has
+// a manager that targets a different container.
+func (e *EmephemeralContainerManager) containerNameForTarget(target string) string {
+	h := fnv.New32()
+	h.Write([]byte(target))
+
+	return fmt.Sprintf("%s-%x", e.ContainerName(), h.Sum32())
+}
+
+// isManagerContainer returns true if the container is a manager container, of any version or target.
+func isManagerContainer(name string) bool {
+	return strings.HasPrefix(name, managerContainerPrefix)
+}
+
+// ContainerNameForID returns the name of the container in the pod with the given container id,
+// as reported by the manager. returns an empty string if no container matches.
+func ContainerNameForID(podObj *corev1.Pod, id string) string {
+	if id == "" {
+		return ""
+	}
+	var statuses []corev1.ContainerStatus
+	statuses = append(statuses, podObj.Status.InitContainerStatuses...)
+	statuses = append(statuses, podObj.Status.ContainerStatuses...)
+	statuses = append(statuses, podObj.Status.EphemeralContainerStatuses...)
+	status, found := lo.Find(statuses, func(t corev1.ContainerStatus) bool {
+		// container ids are in the form of <runtime>://<id>
+		return strings.HasSuffix(t.ContainerID, "://"+id)
+	})
+	if !found {
+		return ""
+	}
+	return status.Name
+}
+
+func (e *EmephemeralContainerManager) ManagerPort(ctx context.Context, podclient typedcorev1.PodInterface, podObj *corev1.Pod, name string) (uint16, error) {
 
-	name := e.ContainerName()
 	port, err := getPortFromLogs(ctx, podclient, podObj.Name, name)
 	if err != nil {
 		return 0, err
@@ -115,10 +165,6 @@ func (e *EmephemeralContainerManager) ManagerPort(ctx context.Context, podclient
 }
 
 func (e *EmephemeralContainerManager) createContainer(ctx context.Context, containerName, dbgimg, target string, pullPolicy corev1.PullPolicy, podObj *corev1.Pod) (*corev1.Pod, error) {
-	if target == "" {
-		target = podObj.Spec.Containers[0].Name
-	}
-
 	trueVar := true
 	ephemeralContainer := corev1.EphemeralContainer{
 		TargetContainerName: target,
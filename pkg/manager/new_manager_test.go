@@ -0,0 +1,93 @@
+package manager
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var _ = Describe("EnsurePodManaged", func() {
+	var (
+		clientset *fake.Clientset
+		mgr       *EmephemeralContainerManager
+		ctx       = context.Background()
+	)
+
+	BeforeEach(func() {
+		clientset = fake.NewSimpleClientset(&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "app"}, {Name: "istio-proxy"}},
+			},
+		})
+		// the ephemeral containers start running as soon as they are added.
+		clientset.PrependReactor("get", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			get := action.(k8stesting.GetAction)
+			obj, err := clientset.Tracker().Get(corev1.SchemeGroupVersion.WithResource("pods"), get.GetNamespace(), get.GetName())
+			if err != nil {
+				return true, nil, err
+			}
+			pod := obj.(*corev1.Pod)
+			pod.Status.EphemeralContainerStatuses = nil
+			for _, c := range pod.Spec.EphemeralContainers {
+				pod.Status.EphemeralContainerStatuses = append(pod.Status.EphemeralContainerStatuses, corev1.ContainerStatus{
+					Name:        c.Name,
+					ContainerID: "containerd://" + c.Name + "-id",
+					State:       corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+				})
+			}
+			return true, pod, nil
+		})
+		mgr = NewEmephemeralContainerManager(clientset.CoreV1())
+	})
+
+	ensure := func(target string) (*corev1.Pod, string) {
+		podObj, name, err := mgr.EnsurePodManaged(ctx, "default", "pod", "kdiag", target, corev1.PullIfNotPresent)
+		Expect(err).NotTo(HaveOccurred())
+		return podObj, name
+	}
+	ephemeralContainers := func() []corev1.EphemeralContainer {
+		podObj, err := clientset.Tracker().Get(corev1.SchemeGroupVersion.WithResource("pods"), "default", "pod")
+		Expect(err).NotTo(HaveOccurred())
+		return podObj.(*corev1.Pod).Spec.EphemeralContainers
+	}
+
+	It("should create a manager that targets the first container by default", func() {
+		podObj, name := ensure("")
+		Expect(name).To(Equal(mgr.ContainerName()))
+		Expect(ephemeralContainers()).To(HaveLen(1))
+		Expect(ephemeralContainers()[0].TargetContainerName).To(Equal("app"))
+		// the pod has the status of the new manager.
+		Expect(ContainerNameForID(podObj, name+"-id")).To(Equal(name))
+	})
+
+	It("should reuse the manager that targets the same container", func() {
+		_, first := ensure("app")
+		_, second := ensure("app")
+		Expect(second).To(Equal(first))
+		Expect(ephemeralContainers()).To(HaveLen(1))
+	})
+
+	It("should create a dedicated manager for another target, and reuse it", func() {
+		_, first := ensure("app")
+		podObj, second := ensure("istio-proxy")
+		Expect(second).To(Equal(mgr.containerNameForTarget("istio-proxy")))
+		Expect(second).NotTo(Equal(first))
+		Expect(ephemeralContainers()).To(HaveLen(2))
+		Expect(ephemeralContainers()[1].TargetContainerName).To(Equal("istio-proxy"))
+		Expect(ContainerNameForID(podObj, second+"-id")).To(Equal(second))
+
+		_, third := ensure("istio-proxy")
+		Expect(third).To(Equal(second))
+		Expect(ephemeralContainers()).To(HaveLen(2))
+		// the default manager is still used for its target.
+		_, fourth := ensure("app")
+		Expect(fourth).To(Equal(first))
+	})
+})
@@ -0,0 +1,149 @@
+package record
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Header is the first line of an asciicast v2 recording.
+// See https://docs.asciinema.org/manual/asciicast/v2/
+type Header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Command   string            `json:"command,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+const (
+	outputEvent = "o"
+	inputEvent  = "i"
+	resizeEvent = "r"
+)
+
+// Recorder writes a terminal session to w in asciicast v2 format. It is safe for concurrent use.
+type Recorder struct {
+	lock  sync.Mutex
+	w     io.Writer
+	start time.Time
+	err   error
+
+	output *eventWriter
+	input  *eventWriter
+}
+
+// NewRecorder writes the header to w, and returns a recorder that writes the events of the
+// session after it. The version and timestamp are set if missing.
+func NewRecorder(w io.Writer, header Header) (*Recorder, error) {
+	r := &Recorder{
+		w:     w,
+		start: time.Now(),
+	}
+	if header.Version == 0 {
+		header.Version = 2
+	}
+	if header.Timestamp == 0 {
+		header.Timestamp = r.start.Unix()
+	}
+	if err := json.NewEncoder(w).Encode(header); err != nil {
+		return nil, fmt.Errorf("failed to write recording header: %w", err)
+	}
+	r.output = &eventWriter{recorder: r, code: outputEvent}
+	r.input = &eventWriter{recorder: r, code: inputEvent}
+	return r, nil
+}
+
+// Output returns a writer that records the data written to it as terminal output.
+func (r *Recorder) Output() io.Writer {
+	return r.output
+}
+
+// Input returns a writer that records the data written to it as terminal input.
+func (r *Recorder) Input() io.Writer {
+	return r.input
+}
+
+// Resize records a change of the terminal size.
+func (r *Recorder) Resize(width, height int) {
+	r.event(resizeEvent, fmt.Sprintf("%dx%d", width, height))
+}
+
+// Close records data still buffered in the writers, and returns the first error that happened while
+// recording, if any. It doesn't close the underlying writer.
+func (r *Recorder) Close() error {
+	r.output.flush()
+	r.input.flush()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.err
+}
+
+func (r *Recorder) event(code, data string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.err != nil {
+		return
+	}
+	elapsed := time.Since(r.start).Seconds()
+	line, err := json.Marshal([]interface{}{elapsed, code, data})
+	if err == nil {
+		_, err = r.w.Write(append(line, '\n'))
+	}
+	if err != nil {
+		r.err = fmt.Errorf("failed to record session: %w", err)
+	}
+}
+
+// eventWriter records writes as events. Event data must be valid utf-8, so a multi-byte character
+// split between writes is held back until it is complete.
+type eventWriter struct {
+	recorder *Recorder
+	code     string
+
+	lock    sync.Mutex
+	pending []byte
+}
+
+func (w *eventWriter) Write(b []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	data := append(w.pending, b...)
+	end := incompleteSuffix(data)
+	w.pending = append([]byte(nil), data[end:]...)
+	if end > 0 {
+		w.recorder.event(w.code, string(data[:end]))
+	}
+	// never fail the session because of the recording. errors are reported by Close.
+	return len(b), nil
+}
+
+func (w *eventWriter) flush() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if len(w.pending) != 0 {
+		w.recorder.event(w.code, string(w.pending))
+		w.pending = nil
+	}
+}
+
+// incompleteSuffix returns the index where a trailing incomplete utf-8 character starts, or len(b)
+// if there is none.
+func incompleteSuffix(b []byte) int {
+	// a utf-8 character is at most utf8.UTFMax bytes long.
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if !utf8.RuneStart(b[i]) {
+			continue
+		}
+		if !utf8.FullRune(b[i:]) {
+			return i
+		}
+		break
+	}
+	return len(b)
+}
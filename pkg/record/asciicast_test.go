@@ -0,0 +1,63 @@
+package record_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/solo-io/kdiag/pkg/record"
+)
+
+func parseRecording(data string) (map[string]interface{}, [][]interface{}) {
+	lines := strings.Split(strings.TrimSuffix(data, "\n"), "\n")
+	var header map[string]interface{}
+	Expect(json.Unmarshal([]byte(lines[0]), &header)).To(Succeed())
+	var events [][]interface{}
+	for _, line := range lines[1:] {
+		var event []interface{}
+		Expect(json.Unmarshal([]byte(line), &event)).To(Succeed())
+		events = append(events, event)
+	}
+	return header, events
+}
+
+var _ = Describe("Recorder", func() {
+	It("should write an asciicast v2 recording", func() {
+		var buf bytes.Buffer
+		r, err := record.NewRecorder(&buf, record.Header{Width: 100, Height: 30, Command: "ash"})
+		Expect(err).NotTo(HaveOccurred())
+		r.Output().Write([]byte("$ ls\r\n"))
+		r.Input().Write([]byte("l"))
+		r.Resize(120, 40)
+		Expect(r.Close()).To(Succeed())
+
+		header, events := parseRecording(buf.String())
+		Expect(header).To(HaveKeyWithValue("version", BeEquivalentTo(2)))
+		Expect(header).To(HaveKeyWithValue("width", BeEquivalentTo(100)))
+		Expect(header).To(HaveKeyWithValue("height", BeEquivalentTo(30)))
+		Expect(header).To(HaveKey("timestamp"))
+		Expect(events).To(HaveLen(3))
+		Expect(events[0][1:]).To(Equal([]interface{}{"o", "$ ls\r\n"}))
+		Expect(events[1][1:]).To(Equal([]interface{}{"i", "l"}))
+		Expect(events[2][1:]).To(Equal([]interface{}{"r", "120x40"}))
+		Expect(events[0][0]).To(BeNumerically("<=", events[2][0]))
+	})
+
+	It("should not split multi-byte characters between events", func() {
+		var buf bytes.Buffer
+		r, err := record.NewRecorder(&buf, record.Header{Width: 80, Height: 24})
+		Expect(err).NotTo(HaveOccurred())
+		data := []byte("héllo")
+		r.Output().Write(data[:2])
+		r.Output().Write(data[2:])
+		Expect(r.Close()).To(Succeed())
+
+		_, events := parseRecording(buf.String())
+		Expect(events).To(HaveLen(2))
+		Expect(events[0][2]).To(Equal("h"))
+		Expect(events[1][2]).To(Equal("éllo"))
+	})
+})
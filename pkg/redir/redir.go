@@ -13,9 +13,11 @@ type Redirection struct {
 	fromPort  uint16
 	localPort uint16
 	outgoing  bool
+	// with outgoing, only redirect connections to localhost.
+	local bool
 }
 
-func NewRedirection(fromPort uint16, outgoing bool) (*Redirection, error) {
+func NewRedirection(fromPort uint16, outgoing, local bool) (*Redirection, error) {
 
 	// connect to the manager in the pod,
 	// start a stream and wait for remote connections
@@ -43,23 +45,32 @@ func NewRedirection(fromPort uint16, outgoing bool) (*Redirection, error) {
 		Listener:  listener,
 		fromPort:  fromPort,
 		outgoing:  outgoing,
+		local:     local,
 		localPort: uint16(localPortUInt)}, nil
 }
 
 func (r *Redirection) Redirect() error {
-	if r.outgoing {
-		return execute("iptables", "-w", "10", "-t", "nat", "-A", "OUTPUT", "-p", "tcp", "--dport", strconv.Itoa(int(r.fromPort)), "-j", "DNAT", "--to-destination", "127.0.0.1:"+strconv.Itoa(int(r.localPort)))
-	}
-	return execute("iptables", "-w", "10", "-t", "nat", "-A", "PREROUTING", "-p", "tcp", "--dport", strconv.Itoa(int(r.fromPort)), "-j", "REDIRECT", "--to-port", strconv.Itoa(int(r.localPort)))
+	return execute("iptables", append([]string{"-w", "10", "-t", "nat", "-A"}, r.rule()...)...)
 }
 
 func (r *Redirection) Close() error {
 	defer r.Listener.Close()
 
+	return execute("iptables", append([]string{"-w", "10", "-t", "nat", "-D"}, r.rule()...)...)
+}
+
+// rule returns the chain and the iptables rule of the redirection.
+func (r *Redirection) rule() []string {
+	if r.outgoing && r.local {
+		// connections to localhost don't go through PREROUTING, and they are already local, so they
+		// are redirected in OUTPUT. this is an iptables rule, so connections to ::1 are not
+		// redirected.
+		return []string{"OUTPUT", "-p", "tcp", "-d", "127.0.0.1/32", "--dport", strconv.Itoa(int(r.fromPort)), "-j", "REDIRECT", "--to-port", strconv.Itoa(int(r.localPort))}
+	}
 	if r.outgoing {
-		return execute("iptables", "-w", "10", "-t", "nat", "-D", "OUTPUT", "-p", "tcp", "--dport", strconv.Itoa(int(r.fromPort)), "-j", "DNAT", "--to-destination", "127.0.0.1:"+strconv.Itoa(int(r.localPort)))
+		return []string{"OUTPUT", "-p", "tcp", "--dport", strconv.Itoa(int(r.fromPort)), "-j", "DNAT", "--to-destination", "127.0.0.1:" + strconv.Itoa(int(r.localPort))}
 	}
-	return execute("iptables", "-w", "10", "-t", "nat", "-D", "PREROUTING", "-p", "tcp", "--dport", strconv.Itoa(int(r.fromPort)), "-j", "REDIRECT", "--to-port", strconv.Itoa(int(r.localPort)))
+	return []string{"PREROUTING", "-p", "tcp", "--dport", strconv.Itoa(int(r.fromPort)), "-j", "REDIRECT", "--to-port", strconv.Itoa(int(r.localPort))}
 }
 
 func execute(cmd string, args ...string) error {
@@ -0,0 +1,13 @@
+package redir
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestRedir(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Redir Suite")
+}
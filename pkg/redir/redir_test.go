@@ -0,0 +1,21 @@
+package redir
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = DescribeTable("rule",
+	func(outgoing, local bool, expected []string) {
+		r := &Redirection{fromPort: 15000, localPort: 40000, outgoing: outgoing, local: local}
+		Expect(r.rule()).To(Equal(expected))
+	},
+	Entry("incoming", false, false,
+		[]string{"PREROUTING", "-p", "tcp", "--dport", "15000", "-j", "REDIRECT", "--to-port", "40000"}),
+	Entry("incoming ignores local", false, true,
+		[]string{"PREROUTING", "-p", "tcp", "--dport", "15000", "-j", "REDIRECT", "--to-port", "40000"}),
+	Entry("outgoing", true, false,
+		[]string{"OUTPUT", "-p", "tcp", "--dport", "15000", "-j", "DNAT", "--to-destination", "127.0.0.1:40000"}),
+	Entry("outgoing to localhost", true, true,
+		[]string{"OUTPUT", "-p", "tcp", "-d", "127.0.0.1/32", "--dport", "15000", "-j", "REDIRECT", "--to-port", "40000"}),
+)
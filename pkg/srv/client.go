@@ -13,10 +13,10 @@ import (
 )
 
 // Stream Envoy access logs as they are captured.
-func Redirect(ctx context.Context, client pb.ManagerClient, outgoing bool, podPort, localPort uint16, newPortForward func(ctx context.Context, podPort uint16) (*frwrd.PortForward, error)) error {
+func Redirect(ctx context.Context, client pb.ManagerClient, outgoing, local bool, podPort, localPort uint16, newPortForward func(ctx context.Context, podPort uint16) (*frwrd.PortForward, error)) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	cli, err := client.Redirect(ctx, &pb.RedirectRequest{Port: uint32(podPort), Outgoing: outgoing})
+	cli, err := client.Redirect(ctx, &pb.RedirectRequest{Port: uint32(podPort), Outgoing: outgoing, Local: local})
 	if err != nil {
 		return err
 	}
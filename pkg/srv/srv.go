@@ -8,6 +8,7 @@ import (
 	"net"
 	"os"
 	"os/exec"
+	"regexp"
 	"time"
 
 	ps "github.com/mitchellh/go-ps"
@@ -32,6 +33,10 @@ const (
 	keepaliveTime = 10 * time.Second
 )
 
+var (
+	containerIDRegexp = regexp.MustCompile(`[0-9a-f]{64}`)
+)
+
 type server struct {
 	pb.UnimplementedManagerServer
 }
@@ -104,7 +109,7 @@ func (s *server) Redirect(r *pb.RedirectRequest, respStream pb.Manager_RedirectS
 		return fmt.Errorf("port number %d is too large", r.Port)
 	}
 
-	redir, err := redir.NewRedirection(uint16(r.Port), r.Outgoing)
+	redir, err := redir.NewRedirection(uint16(r.Port), r.Outgoing, r.Local)
 	if err != nil {
 		return fmt.Errorf("could not create redirection: %w", err)
 	}
@@ -169,6 +174,7 @@ func (s *server) Ps(ctx context.Context, r *pb.PsRequest) (*pb.PsResponse, error
 			Ppid:            uint64(t.PPid()),
 			Name:            t.Executable(),
 			ListenAddresses: addrs,
+			ContainerId:     containerID(t.Pid()),
 		}
 	})
 
@@ -183,6 +189,20 @@ func (s *server) Ps(ctx context.Context, r *pb.PsRequest) (*pb.PsResponse, error
 	return resp, nil
 }
 
+// containerID returns the id of the container the process belongs to. Container runtimes name
+// the cgroup of the container after its id, so we take the last id-like string in the cgroup file.
+func containerID(pid int) string {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return ""
+	}
+	ids := containerIDRegexp.FindAll(data, -1)
+	if len(ids) == 0 {
+		return ""
+	}
+	return string(ids[len(ids)-1])
+}
+
 func (s *server) Pprof(context.Context, *pb.PprofRequest) (*pb.PprofResponse, error) {
 
 	exec.CommandContext(context.Background(), "google-pprof", "")
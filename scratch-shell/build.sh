@@ -122,5 +122,4 @@ if [ ! -d built ]; then
   mkdir built
 fi
 
-cp build/${busybox_dir}/busybox built/ash
-$CC $CFLAGS ./enter.c -o built/enter
\ No newline at end of file
+cp build/${busybox_dir}/busybox built/ash
\ No newline at end of file
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -15,6 +16,7 @@ import (
 	"github.com/solo-io/kdiag/pkg/cmd/diag"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
@@ -121,6 +123,64 @@ var _ = Describe("E2e", func() {
 		Eventually(received1, "10s").Should(BeClosed())
 	})
 
+	It("should redirect traffic of all the matching pods to us", func() {
+		received := make(chan struct{})
+		var once sync.Once
+		go http.ListenAndServe("localhost:8991", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			once.Do(func() {
+				close(received)
+			})
+		}))
+
+		// run redir command
+		root := diag.NewCmdDiag(genericclioptions.IOStreams{In: devNull, Out: GinkgoWriter, ErrOut: GinkgoWriter})
+		root.SetArgs([]string{
+			"-l", labelSelector, "redir", "--all-matching", "80:8991",
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		received1 := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			err := root.ExecuteContext(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			close(received1)
+		}()
+		// the curl pod should hit the nginx pod every second
+		Eventually(received, "20s").Should(BeClosed())
+		cancel()
+		Eventually(received1, "10s").Should(BeClosed())
+	})
+
+	It("should redirect traffic of the pods of a service to us", func() {
+		received := make(chan struct{})
+		var once sync.Once
+		go http.ListenAndServe("localhost:8992", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			once.Do(func() {
+				close(received)
+			})
+		}))
+
+		// run redir command
+		root := diag.NewCmdDiag(genericclioptions.IOStreams{In: devNull, Out: GinkgoWriter, ErrOut: GinkgoWriter})
+		root.SetArgs([]string{
+			"redir", "svc/nginx", "80:8992",
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		received1 := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			err := root.ExecuteContext(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			close(received1)
+		}()
+		// the curl pod should hit the nginx service every second
+		Eventually(received, "20s").Should(BeClosed())
+		cancel()
+		Eventually(received1, "10s").Should(BeClosed())
+	})
+
 	It("should redirect outgoing traffic to us", func() {
 		received := make(chan struct{})
 		var once sync.Once
@@ -164,6 +224,77 @@ var _ = Describe("E2e", func() {
 		Expect(out.String()).To(ContainSubstring("do curl"))
 	})
 
+	It("should propagate the exit code of the shell command", func() {
+		root := diag.NewCmdDiag(genericclioptions.IOStreams{In: devNull, Out: GinkgoWriter, ErrOut: GinkgoWriter})
+		root.SetArgs([]string{"shell", "-l", "app=curl", "--", "-c", "exit 3"})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		err := root.ExecuteContext(ctx)
+		var exitErr *diag.ExitError
+		Expect(errors.As(err, &exitErr)).To(BeTrue())
+		Expect(exitErr.Code).To(Equal(3))
+	})
+
+	It("should run the shell command in all matching pods", func() {
+		out := &SafeWriter{}
+		root := diag.NewCmdDiag(genericclioptions.IOStreams{In: devNull, Out: out, ErrOut: GinkgoWriter})
+		root.SetArgs([]string{"shell", "--all-matching", "-l", "app=curl", "--", "-c", "echo hello; exit 2"})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		err := root.ExecuteContext(ctx)
+		var exitErr *diag.ExitError
+		Expect(errors.As(err, &exitErr)).To(BeTrue())
+		Expect(exitErr.Code).To(Equal(2))
+		Expect(out.Buff.String()).To(MatchRegexp(`curl-.*: hello`))
+	})
+
+	It("should enter the namespaces of a process selected by name", func() {
+		out := &bytes.Buffer{}
+		root := diag.NewCmdDiag(genericclioptions.IOStreams{In: devNull, Out: out, ErrOut: GinkgoWriter})
+		root.SetArgs([]string{"shell", "-l", "app=curl", "--target-process", "sh", "--", "-c", "top -n 1"})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		err := root.ExecuteContext(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(out.String()).To(ContainSubstring("do curl"))
+	})
+
+	It("should run tools from the debug image in the pod's namespaces", func() {
+		out := &bytes.Buffer{}
+		root := diag.NewCmdDiag(genericclioptions.IOStreams{In: devNull, Out: out, ErrOut: GinkgoWriter})
+		root.SetArgs([]string{"exec", "-l", labelSelector, "--", "curl", "-s", "localhost:80"})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		err := root.ExecuteContext(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(out.String()).To(ContainSubstring("Welcome to nginx!"))
+	})
+
+	It("should record exec on pods that require recording", func() {
+		pl, err := clientset.CoreV1().Pods(ns).List(ctx, v1.ListOptions{LabelSelector: labelSelector})
+		Expect(err).NotTo(HaveOccurred())
+		pod := pl.Items[0]
+		// the pod is deleted before each test, so the annotation doesn't affect the others.
+		patch := fmt.Sprintf(`{"metadata":{"annotations":{%q:"local"}}}`, diag.RecordAnnotation)
+		_, err = clientset.CoreV1().Pods(ns).Patch(ctx, pod.Name, types.MergePatchType, []byte(patch), v1.PatchOptions{})
+		Expect(err).NotTo(HaveOccurred())
+
+		recording := filepath.Join(GinkgoT().TempDir(), "exec.cast")
+		root := diag.NewCmdDiag(genericclioptions.IOStreams{In: devNull, Out: GinkgoWriter, ErrOut: GinkgoWriter})
+		root.SetArgs([]string{"exec", "--pod", pod.Name, "--record-file", recording, "--", "curl", "-s", "localhost:80"})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		Expect(root.ExecuteContext(ctx)).To(Succeed())
+		data, err := os.ReadFile(recording)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(ContainSubstring("Welcome to nginx!"))
+	})
+
 	It("should show logs from both apps a top in the shell even though its not in the image", func() {
 		out := &bytes.Buffer{}
 		root := diag.NewCmdDiag(genericclioptions.IOStreams{In: devNull, Out: out, ErrOut: out})
@@ -208,6 +339,32 @@ var _ = Describe("E2e", func() {
 		Expect(ctx.Err()).NotTo(HaveOccurred())
 		Expect(out.Buff.String()).To(ContainSubstring(`GET /test HTTP/1.1" 404`)) //nginx
 	})
+
+	It("should show the namespace of pods selected with a namespace", func() {
+		out := &SafeWriter{}
+		root := diag.NewCmdDiag(genericclioptions.IOStreams{In: devNull, Out: out, ErrOut: out})
+		root.SetArgs([]string{"logs", "-n", "kube-system", "-l", ns + "/" + labelSelector, "--tail", "1", "--snapshot"})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		err := root.ExecuteContext(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(out.Buff.String()).To(MatchRegexp(`(?m)^` + ns + `/nginx-.*: `))
+	})
+
+	It("should exit with the exit code of the logs command", func() {
+		out := &SafeWriter{}
+		root := diag.NewCmdDiag(genericclioptions.IOStreams{In: devNull, Out: out, ErrOut: out})
+		root.SetArgs([]string{"logs", "-l", labelSelector, "--drain-duration", "0s", "--", "sh", "-c", "exit 3"})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		err := root.ExecuteContext(ctx)
+
+		var exitErr *diag.ExitError
+		Expect(errors.As(err, &exitErr)).To(BeTrue())
+		Expect(exitErr.Code).To(Equal(3))
+	})
 })
 
 type SafeWriter struct {